@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -20,6 +23,69 @@ func checkIfError(err error) int {
 	return 1
 }
 
+// Each rule is given as a regular expression and a URL separated by the last space
+type autolinkFlags []views.AutolinkRule
+
+func (rules *autolinkFlags) String() string {
+	return fmt.Sprintf("%d rules", len(*rules))
+}
+
+func (rules *autolinkFlags) Set(value string) error {
+	split := strings.LastIndex(value, " ")
+	if split == -1 {
+		return errors.New("expected a pattern and a URL separated by a space")
+	}
+	pattern, err := regexp.Compile(value[:split])
+	if err != nil {
+		return err
+	}
+	*rules = append(*rules, views.AutolinkRule{Pattern: pattern, URL: value[split+1:]})
+	return nil
+}
+
+// Each site is given as the submodule's URL and where its pages are separated by the last =
+type submoduleSiteFlags []views.SubmoduleSite
+
+func (sites *submoduleSiteFlags) String() string {
+	return fmt.Sprintf("%d sites", len(*sites))
+}
+
+func (sites *submoduleSiteFlags) Set(value string) error {
+	split := strings.LastIndex(value, "=")
+	if split <= 0 || split == len(value)-1 {
+		return errors.New("expected a submodule URL and the path or URL of its pages separated by =")
+	}
+	*sites = append(*sites, views.SubmoduleSite{URL: value[:split], Pages: value[split+1:]})
+	return nil
+}
+
+type stringFlags []string
+
+func (values *stringFlags) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *stringFlags) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+// Each pair is given as base...head
+type compareFlags []views.ComparePair
+
+func (pairs *compareFlags) String() string {
+	return fmt.Sprintf("%d pairs", len(*pairs))
+}
+
+func (pairs *compareFlags) Set(value string) error {
+	base, head, found := strings.Cut(value, "...")
+	if !found || base == "" || head == "" {
+		return errors.New("expected a pair of revisions separated by ...")
+	}
+	*pairs = append(*pairs, views.ComparePair{Base: base, Head: head})
+	return nil
+}
+
 func internalMain(repositoryPath string, repositoryName string, config views.Config) int {
 	repository, err := git.PlainOpen(repositoryPath)
 	if res := checkIfError(err); res != 0 {
@@ -32,7 +98,12 @@ func internalMain(repositoryPath string, repositoryName string, config views.Con
 		return res
 	}
 
-	err = views.WriteCommits(repository, repositoryName, baseDir, config)
+	index, err := views.NewRepoIndex(repository, baseDir, config)
+	if res := checkIfError(err); res != 0 {
+		return res
+	}
+
+	err = views.WriteCommits(repository, index, repositoryName, baseDir, config)
 	if res := checkIfError(err); res != 0 {
 		return res
 	}
@@ -41,13 +112,42 @@ func internalMain(repositoryPath string, repositoryName string, config views.Con
 	checkIfError(err)
 	defer branchIter.Close()
 	err = branchIter.ForEach(func(branch *plumbing.Reference) error {
-		return views.WriteBranch(branch, repository, repositoryName, baseDir, config)
+		if !config.BranchFilter.Allows(branch.Name().Short()) {
+			return nil
+		}
+		return views.WriteBranch(branch, repository, index, repositoryName, baseDir, config)
 	})
 	if res := checkIfError(err); res != 0 {
 		return res
 	}
 
-	err = views.WriteRefs(repository, repositoryName, baseDir, config)
+	namespaceRefs, err := views.CollectNamespaceRefs(repository, config)
+	if res := checkIfError(err); res != 0 {
+		return res
+	}
+	for _, ref := range namespaceRefs {
+		err = views.WriteBranch(ref, repository, index, repositoryName, baseDir, config)
+		if res := checkIfError(err); res != 0 {
+			return res
+		}
+	}
+
+	err = views.WriteHome(repository, index, repositoryName, baseDir, config)
+	if res := checkIfError(err); res != 0 {
+		return res
+	}
+
+	err = views.WriteRefs(repository, index, repositoryName, baseDir, config)
+	if res := checkIfError(err); res != 0 {
+		return res
+	}
+
+	err = views.WriteCompares(repository, index, repositoryName, baseDir, config)
+	if res := checkIfError(err); res != 0 {
+		return res
+	}
+
+	err = index.WriteTipRefs(baseDir)
 	if res := checkIfError(err); res != 0 {
 		return res
 	}
@@ -62,9 +162,57 @@ func main() {
 	}
 	var logLimit = flag.Uint("l", 0, "Limit on the number of commits to render in the log with 0 giving no limit (default 0)")
 	var stylePath = flag.String("s", "../static/styles.css", "Relative path from public to look for styles")
+	var diffFileLimit = flag.Uint("diff-file-limit", 1000, "Changed lines in a single file above which its diff is suppressed with 0 giving no limit")
+	var diffCommitLimit = flag.Uint("diff-commit-limit", 10000, "Changed lines in a commit above which the remaining file diffs are suppressed with 0 giving no limit")
+	var commitFiles = flag.Bool("commit-files", true, "Render the files changed by each commit as they were at that commit so that diff line numbers link to them")
+	var gpgKeyring = flag.String("gpg-keyring", "", "Path to a GPG keyring to verify commit and tag signatures against")
+	var allowedSigners = flag.String("allowed-signers", "", "Path to an ssh allowed signers file to verify commit and tag signatures against")
+	var autolinks autolinkFlags
+	flag.Var(&autolinks, "autolink", "A \"pattern URL\" pair linking matches of the pattern in commit messages to the URL, which may use $1 etc. for submatches (repeatable)")
+	var compares compareFlags
+	flag.Var(&compares, "compare", "A base...head pair of revisions to generate a compare page for (repeatable)")
+	var compareDefault = flag.Bool("compare-default", false, "Generate compare pages for each branch against the default branch")
+	var compareTags = flag.Bool("compare-tags", false, "Generate compare pages for each tag against the previous tag")
+	var remotes = flag.Bool("remotes", false, "Render remote-tracking branches like local branches")
+	var namespaces stringFlags
+	flag.Var(&namespaces, "namespace", "A ref namespace, e.g., refs/pull, whose refs are rendered like branches (repeatable)")
+	var refIncludes stringFlags
+	flag.Var(&refIncludes, "ref-include", "A glob over full ref names, e.g., refs/pull/*/head, limiting which remote-tracking and namespaced refs are rendered (repeatable)")
+	var refExcludes stringFlags
+	flag.Var(&refExcludes, "ref-exclude", "A glob over full ref names excluding remote-tracking and namespaced refs from being rendered (repeatable)")
+	var branchIncludes stringFlags
+	flag.Var(&branchIncludes, "branch-include", "A glob over branch names, e.g., release/*, limiting which branches are rendered (repeatable)")
+	var branchExcludes stringFlags
+	flag.Var(&branchExcludes, "branch-exclude", "A glob over branch names excluding branches from being rendered (repeatable)")
+	var pathIncludes stringFlags
+	flag.Var(&pathIncludes, "path-include", "A glob over paths in the tree, e.g., docs, limiting which files and folders are rendered (repeatable)")
+	var pathExcludes stringFlags
+	flag.Var(&pathExcludes, "path-exclude", "A glob over paths in the tree, e.g., vendor or **/testdata, excluding files and folders from being rendered (repeatable)")
+	var submoduleSites submoduleSiteFlags
+	flag.Var(&submoduleSites, "submodule-pages", "A \"URL=pages\" pair linking submodules with the URL to the pages generated for them, which are relative to public unless absolute (repeatable)")
+	var tocHeadings = flag.Uint("toc", 0, "Number of headings at or above which READMEs and markdown files get a table of contents with 0 giving none (default 0)")
 	flag.Parse()
 
-	config := views.Config{*logLimit, *stylePath}
+	config := views.Config{
+		LogLimit:        *logLimit,
+		StylePath:       *stylePath,
+		DiffFileLimit:   *diffFileLimit,
+		DiffCommitLimit: *diffCommitLimit,
+		CommitFiles:     *commitFiles,
+		GPGKeyring:      *gpgKeyring,
+		AllowedSigners:  *allowedSigners,
+		Autolinks:       autolinks,
+		Compares:        compares,
+		CompareDefault:  *compareDefault,
+		CompareTags:     *compareTags,
+		Remotes:         *remotes,
+		Namespaces:      namespaces,
+		RefFilter:       views.Filter{Include: refIncludes, Exclude: refExcludes},
+		BranchFilter:    views.Filter{Include: branchIncludes, Exclude: branchExcludes},
+		PathFilter:      views.Filter{Include: pathIncludes, Exclude: pathExcludes},
+		SubmoduleSites:  submoduleSites,
+		TocHeadings:     *tocHeadings,
+	}
 
 	if flag.NArg() != 2 {
 		flag.Usage()
@@ -0,0 +1,177 @@
+package views
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type archiveFormat struct {
+	Extension string
+	write     func(tree *object.Tree, prefix string, modTime time.Time, writer io.Writer) error
+}
+
+var archiveFormats = []archiveFormat{
+	{".tar.gz", writeTarGz},
+	{".zip", writeZip},
+}
+
+// Like git archive, every file is placed under prefix and submodules are left out
+func walkArchive(tree *object.Tree, prefix string, add func(name string, entry object.TreeEntry, file *object.File) error) error {
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var file *object.File = nil
+		switch entry.Mode {
+		case filemode.Submodule:
+			continue
+		case filemode.Dir:
+		default:
+			file, err = tree.TreeEntryFile(&entry)
+			if err != nil {
+				return err
+			}
+		}
+		err = add(path.Join(prefix, name), entry, file)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeTarGz(tree *object.Tree, prefix string, modTime time.Time, writer io.Writer) error {
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err := walkArchive(tree, prefix, func(name string, entry object.TreeEntry, file *object.File) error {
+		header := tar.Header{
+			Name:    name,
+			ModTime: modTime,
+			Mode:    0644,
+		}
+		switch entry.Mode {
+		case filemode.Dir:
+			header.Typeflag = tar.TypeDir
+			header.Name = name + "/"
+			header.Mode = 0755
+			return tarWriter.WriteHeader(&header)
+		case filemode.Symlink:
+			target, err := file.Contents()
+			if err != nil {
+				return err
+			}
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = target
+			header.Mode = 0777
+			return tarWriter.WriteHeader(&header)
+		case filemode.Executable:
+			header.Mode = 0755
+		}
+		header.Typeflag = tar.TypeReg
+		header.Size = file.Size
+		err := tarWriter.WriteHeader(&header)
+		if err != nil {
+			return err
+		}
+		return copyFile(file, tarWriter)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func writeZip(tree *object.Tree, prefix string, modTime time.Time, writer io.Writer) error {
+	zipWriter := zip.NewWriter(writer)
+	err := walkArchive(tree, prefix, func(name string, entry object.TreeEntry, file *object.File) error {
+		header := zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		switch entry.Mode {
+		case filemode.Dir:
+			header.Name = name + "/"
+			header.SetMode(os.ModeDir | 0755)
+			_, err := zipWriter.CreateHeader(&header)
+			return err
+		case filemode.Symlink:
+			header.SetMode(os.ModeSymlink | 0777)
+		case filemode.Executable:
+			header.SetMode(0755)
+		default:
+			header.SetMode(0644)
+		}
+		fileWriter, err := zipWriter.CreateHeader(&header)
+		if err != nil {
+			return err
+		}
+		return copyFile(file, fileWriter)
+	})
+	if err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
+
+func copyFile(file *object.File, writer io.Writer) error {
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+// Archives are only written when missing or older than modTime since they are slow to produce
+func writeArchives(tree *object.Tree, prefix string, modTime time.Time, basePath string) ([]string, error) {
+	archives := make([]string, 0, len(archiveFormats))
+	for _, format := range archiveFormats {
+		archivePath := basePath + format.Extension
+		archives = append(archives, filepath.Base(archivePath))
+
+		skip, err := isSkipWrite(archivePath, modTime)
+		if err != nil {
+			return nil, err
+		}
+		if skip {
+			continue
+		}
+
+		file, err := os.Create(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		err = format.write(tree, prefix, modTime, file)
+		closeErr := file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if closeErr != nil {
+			return nil, closeErr
+		}
+	}
+	return archives, nil
+}
@@ -0,0 +1,82 @@
+package views
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const gitattributesFile = ".gitattributes"
+
+// Only the .gitattributes files in the directories leading to the given paths are read
+// since those are the only ones which can apply to them. They are given to the matcher
+// from the root down as deeper files take priority.
+func attributesForPaths(tree *object.Tree, paths []string) (gitattributes.Matcher, error) {
+	dirSet := make(map[string]bool)
+	for _, filePath := range paths {
+		dir := path.Dir(filePath)
+		for {
+			if dir == "." {
+				dir = ""
+			}
+			dirSet[dir] = true
+			if dir == "" {
+				break
+			}
+			dir = path.Dir(dir)
+		}
+	}
+
+	dirs := make([]string, 0, len(dirSet))
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+	depth := func(dir string) int {
+		if dir == "" {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		iDepth, jDepth := depth(dirs[i]), depth(dirs[j])
+		if iDepth != jDepth {
+			return iDepth < jDepth
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	stack := make([]gitattributes.MatchAttribute, 0)
+	for _, dir := range dirs {
+		file, err := tree.File(path.Join(dir, gitattributesFile))
+		if errors.Is(err, object.ErrFileNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if file.Mode == filemode.Symlink {
+			continue
+		}
+
+		reader, err := file.Reader()
+		if err != nil {
+			return nil, err
+		}
+		var domain []string
+		if dir != "" {
+			domain = strings.Split(dir, "/")
+		}
+		// Like git, macros may only be defined at the top level
+		attributes, err := gitattributes.ReadAttributes(reader, domain, dir == "")
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, attributes...)
+	}
+	return gitattributes.NewMatcher(stack), nil
+}
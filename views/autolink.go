@@ -0,0 +1,123 @@
+package views
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The URL may refer to the pattern's submatches as with regexp.Expand, e.g., a pattern
+// of #(\d+) with a URL of https://example.com/issues/$1
+type AutolinkRule struct {
+	Pattern *regexp.Regexp
+	URL     string
+}
+
+var (
+	urlRegexp  = regexp.MustCompile(`\bhttps?://[^\s<>"]+`)
+	hashRegexp = regexp.MustCompile(`\b[0-9a-fA-F]{7,40}\b`)
+)
+
+type autolink struct {
+	start int
+	end   int
+	href  string
+}
+
+func findAutolinks(text string, index *RepoIndex, rules []AutolinkRule, root string) []autolink {
+	links := make([]autolink, 0)
+
+	for _, match := range urlRegexp.FindAllStringIndex(text, -1) {
+		// Punctuation ending a sentence is very rarely part of the URL
+		end := match[0] + len(strings.TrimRight(text[match[0]:match[1]], ".,;:!?)'"))
+		links = append(links, autolink{match[0], end, text[match[0]:end]})
+	}
+	for _, rule := range rules {
+		for _, match := range rule.Pattern.FindAllStringSubmatchIndex(text, -1) {
+			href := rule.Pattern.ExpandString(nil, rule.URL, text, match)
+			links = append(links, autolink{match[0], match[1], string(href)})
+		}
+	}
+	if index != nil {
+		for _, match := range hashRegexp.FindAllStringIndex(text, -1) {
+			if hash, ok := index.lookupCommit(text[match[0]:match[1]]); ok {
+				links = append(links, autolink{match[0], match[1], fmt.Sprintf("%sc/%s.html", root, hash)})
+			}
+		}
+	}
+
+	// Overlapping matches are resolved in favour of the earliest and then the longest
+	sort.SliceStable(links, func(i, j int) bool {
+		if links[i].start != links[j].start {
+			return links[i].start < links[j].start
+		}
+		return links[i].end > links[j].end
+	})
+	resolved := make([]autolink, 0, len(links))
+	for _, link := range links {
+		if len(resolved) != 0 && link.start < resolved[len(resolved)-1].end {
+			continue
+		}
+		resolved = append(resolved, link)
+	}
+	return resolved
+}
+
+// Text between the links is linked to plainHref when it isn't empty since links can't nest.
+// Links are found in the whole text before only its first limit characters are shown,
+// or all of it when limit is 0, so that a link cut short still goes to the right place.
+func autolinkText(text string, index *RepoIndex, rules []AutolinkRule, root string, plainHref string, limit int) template.HTML {
+	remaining := limit
+	clip := func(visible string) string {
+		if limit == 0 {
+			return visible
+		}
+		runes := []rune(visible)
+		if len(runes) > remaining {
+			runes = runes[:remaining]
+		}
+		remaining -= len(runes)
+		return string(runes)
+	}
+
+	var sb strings.Builder
+	writePlain := func(plain string) {
+		plain = clip(plain)
+		if plain == "" {
+			return
+		}
+		if plainHref != "" {
+			fmt.Fprintf(&sb, `<a href="%s">%s</a>`, template.HTMLEscapeString(plainHref), template.HTMLEscapeString(plain))
+		} else {
+			sb.WriteString(template.HTMLEscapeString(plain))
+		}
+	}
+
+	position := 0
+	for _, link := range findAutolinks(text, index, rules, root) {
+		writePlain(text[position:link.start])
+		if visible := clip(text[link.start:link.end]); visible != "" {
+			fmt.Fprintf(&sb, `<a href="%s">%s</a>`, template.HTMLEscapeString(link.href), template.HTMLEscapeString(visible))
+		}
+		position = link.end
+	}
+	writePlain(text[position:])
+	return template.HTML(sb.String())
+}
+
+// The functions are closures since the links depend on where the page is written
+func autolinkFuncMap(index *RepoIndex, rules []AutolinkRule, root string) template.FuncMap {
+	return template.FuncMap{
+		"Autolink": func(text string) template.HTML {
+			return autolinkText(text, index, rules, root, "", 0)
+		},
+		"AutolinkClipped": func(text string, limit int) template.HTML {
+			return autolinkText(text, index, rules, root, "", limit)
+		},
+		"AutolinkWithin": func(text string, plainHref string, limit int) template.HTML {
+			return autolinkText(text, index, rules, root, plainHref, limit)
+		},
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,35 +17,95 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
 type File struct {
+	Name string
 	Mode FileMode
 	Size string
 	Link string
+	// Submodules link to their pinned commit, which may be relative to the output root
+	Hash    plumbing.Hash
+	Local   bool
+	Symlink SymlinkData
+	// Nil when the tree has no history, e.g., tagged trees
+	LastCommit *LastCommit
+}
+
+// What every listing within a tree needs to know about the tree as a whole
+type treeContext struct {
+	root        *object.Tree
+	storer      storer.EncodedObjectStorer
+	submodules  *submoduleLinker
+	pathFilter  Filter
+	tocHeadings uint
+	// Only the trees of commits have a history to find the last commits in
+	lastCommits map[string]LastCommit
+}
+
+// The commit may be nil for trees which aren't a commit's, e.g., tagged trees
+func newTreeContext(repository *git.Repository, tree *object.Tree, commit *object.Commit, index *RepoIndex, config Config) (*treeContext, error) {
+	submodules, err := newSubmoduleLinker(tree, index, config.SubmoduleSites)
+	if err != nil {
+		return nil, err
+	}
+	context := treeContext{
+		root:        tree,
+		storer:      repository.Storer,
+		submodules:  submodules,
+		pathFilter:  config.PathFilter,
+		tocHeadings: config.TocHeadings,
+	}
+	if commit != nil {
+		context.lastCommits, err = index.lastCommits(commit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &context, nil
+}
+
+// go-git caches the trees it passes through when finding a path without a lock, so each
+// goroutine writing pages finds paths in its own copy of the root
+func (context *treeContext) copyForGoroutine() (*treeContext, error) {
+	root, err := object.GetTree(context.storer, context.root.Hash)
+	if err != nil {
+		return nil, err
+	}
+	copied := *context
+	copied.root = root
+	return &copied, nil
 }
 
 type TreeData struct {
-	Readme   template.HTML
-	Tree     map[string]File
+	Readme template.HTML
+	Tree   []File
+	// The folder of the entries' pages relative to the listing's page, which is escaped
+	// already as it may be outside of the tree, e.g., the branch's folder on the landing page
 	TreeName string
+	Root     string
 }
 
 type BlobData struct {
 	Lines     []string
 	LineCount []int
 	IsBinary  bool
-	Markdown  template.HTML
+	// Documents are rendered and data files shown as tables as well as shown as source
+	Rendered template.HTML
+	Table    *TableData
 }
 
 type LogCommit struct {
-	Hash    plumbing.Hash
-	Author  string
-	Date    time.Time
-	Message string
-	Refs    []ShortRef
-	Stats   LogStats
+	Hash      plumbing.Hash
+	Author    string
+	CoAuthors []string
+	Date      time.Time
+	Message   string
+	Refs      []ShortRef
+	Stats     LogStats
+	Signature SignatureData
 }
 
 type LogStats struct {
@@ -66,11 +128,13 @@ var fileFuncMap = template.FuncMap{
 	"Executable": func() FileMode { return EXECUTABLE_E },
 	"Symlink":    func() FileMode { return SYMLINK_E },
 	"Submodule":  func() FileMode { return SUBMODULE_E },
+	"TreePage":   treePage,
 }
 
 // This global is treated as a constant and should only be read
 // It acts as a string mapping for each reference enum for the templates
 var refFuncMap = template.FuncMap{
+	"BranchTable": newBranchTable,
 	"RefEnumToString": func(enum RefType) string {
 		var representation string = ""
 		switch enum {
@@ -89,31 +153,46 @@ var refFuncMap = template.FuncMap{
 		}
 		return representation
 	},
+	"TagPage": tagPage,
 }
 
-func (data *TreeData) fromTreeAndSubmodules(tree *object.Tree, submoduleMap map[string]string) error {
-	data.Tree = make(map[string]File, 0)
+// The listing is of tree at dirPath within the context's tree, and its page is in the
+// directory treeBase relative to the tree's pages, which symlink targets are linked from
+func (data *TreeData) fromTreeAndSubmodules(context *treeContext, tree *object.Tree, dirPath string, treeBase string) error {
+	data.Tree = make([]File, 0, len(tree.Entries))
+	bestReadme := len(readmeNames)
 
 	for _, entry := range tree.Entries {
 		var prettySize string = ""
 		var link string = ""
+		var local bool = false
+		var symlink SymlinkData
 		name := entry.Name
 		mode := entry.Mode
+		if !context.pathFilter.AllowsPath(path.Join(dirPath, name), mode == filemode.Dir) {
+			continue
+		}
 		if mode == filemode.Submodule {
-			link = submoduleMap[name]
-		} else if mode != filemode.Symlink && mode != filemode.Dir {
+			link, local = context.submodules.link(path.Join(dirPath, name), entry.Hash)
+		} else if mode == filemode.Symlink {
+			target, err := readSymlink(tree, &entry)
+			if err != nil {
+				return err
+			}
+			symlink = resolveSymlink(context.root, path.Join(dirPath, name), target)
+			symlink.link(treeBase, context.pathFilter)
+		} else if mode != filemode.Dir {
 			file, err := tree.TreeEntryFile(&entry)
 			if err != nil {
 				return err
 			}
-			switch strings.ToLower(name) {
-			// If the markdown conversion fails, just don't render it
-			case "readme":
-				if data.Readme == "" {
-					data.Readme, _ = mdToHtml(file)
+			if format, rank, ok := readmeRank(name); ok && rank < bestReadme {
+				// If the conversion fails, just don't render it
+				readme, err := documentToHtml(file, format, &markdownLinks{context, dirPath, treeBase})
+				if err == nil {
+					data.Readme = readme
+					bestReadme = rank
 				}
-			case "readme.md":
-				data.Readme, _ = mdToHtml(file)
 			}
 
 			isBinary, err := file.IsBinary()
@@ -136,16 +215,34 @@ func (data *TreeData) fromTreeAndSubmodules(tree *object.Tree, submoduleMap map[
 			}
 		}
 
-		data.Tree[name] = File{
-			Mode: modeToEnum[mode],
-			Size: prettySize,
-			Link: link,
+		var lastCommit *LastCommit = nil
+		if found, ok := context.lastCommits[path.Join(dirPath, name)]; ok {
+			lastCommit = &found
 		}
+		data.Tree = append(data.Tree, File{
+			Name:       name,
+			Mode:       modeToEnum[mode],
+			Size:       prettySize,
+			Link:       link,
+			Hash:       entry.Hash,
+			Local:      local,
+			Symlink:    symlink,
+			LastCommit: lastCommit,
+		})
 	}
+	// Directories, along with submodules, are listed before files
+	sort.SliceStable(data.Tree, func(i, j int) bool {
+		iDir := data.Tree[i].Mode == DIR_E || data.Tree[i].Mode == SUBMODULE_E
+		jDir := data.Tree[j].Mode == DIR_E || data.Tree[j].Mode == SUBMODULE_E
+		if iDir != jDir {
+			return iDir
+		}
+		return data.Tree[i].Name < data.Tree[j].Name
+	})
 	return nil
 }
 
-func (data *BlobData) fromFile(file *object.File) error {
+func (data *BlobData) fromFile(file *object.File, links *markdownLinks) error {
 	bin, err := file.IsBinary()
 	if err != nil {
 		return err
@@ -164,11 +261,19 @@ func (data *BlobData) fromFile(file *object.File) error {
 			data.LineCount[idx] = idx + 1
 		}
 
-		if strings.HasSuffix(strings.ToLower(file.Name), ".md") {
-			data.Markdown, err = mdToHtml(file)
+		if format, ok := documentFormat(file.Name); ok {
+			data.Rendered, err = documentToHtml(file, format, links)
 			if err != nil {
-				// If the markdown conversion fails, just don't render it
-				data.Markdown = ""
+				// If the conversion fails, just don't render it
+				data.Rendered = ""
+			}
+		}
+
+		if separator, ok := tableSeparator(file.Name); ok {
+			var table TableData
+			// If the file isn't valid or is empty, just show the source
+			if table.fromFile(file, separator) == nil && (table.Header != nil || len(table.Rows) != 0) {
+				data.Table = &table
 			}
 		}
 	}
@@ -176,9 +281,13 @@ func (data *BlobData) fromFile(file *object.File) error {
 	return nil
 }
 
-func (data *LogData) fromBranchAndRefs(top *object.Commit, refs map[plumbing.Hash][]ShortRef, logLimit uint) error {
+func (data *LogData) fromBranchAndRefs(top *object.Commit, refs map[plumbing.Hash][]ShortRef, verifier *Verifier, logLimit uint) error {
 	commitIter := object.NewCommitIterCTime(top, nil, nil)
 	defer commitIter.Close()
+	return data.fromIterAndRefs(commitIter, refs, verifier, logLimit)
+}
+
+func (data *LogData) fromIterAndRefs(commitIter object.CommitIter, refs map[plumbing.Hash][]ShortRef, verifier *Verifier, logLimit uint) error {
 	var commitCount uint = 0
 	if logLimit == 0 {
 		// Since we check equality when we break, this will function as no limit
@@ -199,13 +308,15 @@ func (data *LogData) fromBranchAndRefs(top *object.Commit, refs map[plumbing.Has
 			return err
 		}
 		message := strings.Split(commit.Message, "\n\n")[0]
+		_, trailers := parseTrailers(commit.Message)
 		logEntry := LogCommit{
-			Hash:    commit.Hash,
-			Author:  commit.Author.Name,
-			Date:    commit.Author.When,
-			Message: message,
-			Refs:    refs[commit.Hash],
-			Stats:   LogStats{0, 0, 0},
+			Hash:      commit.Hash,
+			Author:    commit.Author.Name,
+			CoAuthors: coAuthors(trailers),
+			Date:      commit.Author.When,
+			Message:   message,
+			Refs:      refs[commit.Hash],
+			Stats:     LogStats{0, 0, 0},
 		}
 		// PERFORMANCE: Calling stats for every commit is expensive.
 		stats, err := commit.Stats()
@@ -217,14 +328,18 @@ func (data *LogData) fromBranchAndRefs(top *object.Commit, refs map[plumbing.Has
 			logEntry.Stats.Additions += stat.Addition
 			logEntry.Stats.Deletions += stat.Deletion
 		}
+		logEntry.Signature, err = verifier.verifyCommit(commit)
+		if err != nil {
+			return err
+		}
 		data.Commits = append(data.Commits, logEntry)
 	}
 	return nil
 }
 
-func getSubmoduleNameUrlMap(branch *object.Commit, repository *git.Repository) (map[string]string, error) {
+func getSubmoduleNameUrlMap(tree *object.Tree) (map[string]string, error) {
 	var mapping map[string]string = make(map[string]string)
-	subModFile, err := branch.File(".gitmodules")
+	subModFile, err := tree.File(".gitmodules")
 	if errors.Is(err, object.ErrFileNotFound) {
 		return mapping, nil
 	} else if err != nil {
@@ -256,13 +371,16 @@ func getSubmoduleNameUrlMap(branch *object.Commit, repository *git.Repository) (
 	return mapping, nil
 }
 
-func generateTree(subTree *object.Tree, submoduleMap map[string]string, treeName string, base BaseData, buffer *bytes.Buffer) error {
+func generateTree(context *treeContext, subTree *object.Tree, treeName string, fullPath string, base BaseData, buffer *bytes.Buffer) error {
 	var treeData TreeData
-	err := treeData.fromTreeAndSubmodules(subTree, submoduleMap)
+	// The page of a/b is a/b.html, which is one directory below the tree's pages
+	treeBase := strings.Repeat("../", strings.Count(fullPath, "/"))
+	err := treeData.fromTreeAndSubmodules(context, subTree, fullPath, treeBase)
 	if err != nil {
 		return err
 	}
-	treeData.TreeName = treeName
+	treeData.TreeName = escapeTreeName(treeName)
+	treeData.Root = base.Root
 
 	partialsPath := filepath.Join("templates", "partials")
 	basePath := filepath.Join("templates", "base.html")
@@ -270,11 +388,12 @@ func generateTree(subTree *object.Tree, submoduleMap map[string]string, treeName
 	footPath := filepath.Join(partialsPath, "footer.html")
 	dirPath := filepath.Join(partialsPath, "content", "directory.html")
 	treePath := filepath.Join(partialsPath, "tree.html")
+	symlinkPath := filepath.Join(partialsPath, "symlink.html")
 	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
 	if err != nil {
 		return err
 	}
-	treeTempl, err := template.Must(baseTempl.ParseFS(templates, dirPath)).Funcs(fileFuncMap).ParseFS(templates, treePath)
+	treeTempl, err := template.Must(baseTempl.ParseFS(templates, dirPath)).Funcs(fileFuncMap).ParseFS(templates, treePath, symlinkPath)
 	if err != nil {
 		return err
 	}
@@ -289,58 +408,64 @@ func generateTree(subTree *object.Tree, submoduleMap map[string]string, treeName
 	return err
 }
 
-func generateBlob(file *object.File, base BaseData, buffer *bytes.Buffer) error {
+// Symlinks show where they point rather than the target path as their contents, and
+// markdown only has its links rewritten when links isn't nil
+func generateBlob(file *object.File, symlink *SymlinkData, links *markdownLinks, notes []NoteData, base BaseData, buffer *bytes.Buffer) error {
 	var blobData BlobData
-	blobData.fromFile(file)
+	blobData.fromFile(file, links)
 
 	partialsPath := filepath.Join("templates", "partials")
 	basePath := filepath.Join("templates", "base.html")
 	navPath := filepath.Join(partialsPath, "nav.html")
 	filePath := filepath.Join(partialsPath, "content", "file.html")
 	blobPath := filepath.Join(partialsPath, "blob.html")
+	notesPath := filepath.Join(partialsPath, "notes.html")
+	symlinkPath := filepath.Join(partialsPath, "symlink.html")
 	footPath := filepath.Join(partialsPath, "footer.html")
 	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
 	if err != nil {
 		return err
 	}
-	blobTempl, err := template.Must(baseTempl.ParseFS(templates, filePath)).ParseFS(templates, blobPath)
+	blobTempl, err := template.Must(baseTempl.Funcs(noteFuncMap).ParseFS(templates, filePath)).ParseFS(templates, blobPath, notesPath, symlinkPath)
 	if err != nil {
 		return err
 	}
 
 	err = blobTempl.Execute(buffer, struct {
-		Blob BlobData
+		Blob    BlobData
+		Symlink *SymlinkData
+		Notes   []NoteData
 		BaseData
 	}{
 		blobData,
+		symlink,
+		notes,
 		base,
 	})
 	return err
 }
 
-func generateIndex(branch *object.Commit, submoduleMap map[string]string, treePrefix string, base BaseData, buffer *bytes.Buffer) error {
+func generateIndex(context *treeContext, treePrefix string, base BaseData, buffer *bytes.Buffer) error {
 	var treeData TreeData
-	tree, err := branch.Tree()
-	if err != nil {
-		return err
-	}
-	err = treeData.fromTreeAndSubmodules(tree, submoduleMap)
+	err := treeData.fromTreeAndSubmodules(context, context.root, "", treePrefix+"/")
 	if err != nil {
 		return err
 	}
 	treeData.TreeName = treePrefix
+	treeData.Root = base.Root
 
 	partialsPath := filepath.Join("templates", "partials")
 	basePath := filepath.Join("templates", "base.html")
 	navPath := filepath.Join(partialsPath, "nav.html")
 	branchPath := filepath.Join(partialsPath, "content", "branch.html")
 	treePath := filepath.Join(partialsPath, "tree.html")
+	symlinkPath := filepath.Join(partialsPath, "symlink.html")
 	footPath := filepath.Join(partialsPath, "footer.html")
 	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
 	if err != nil {
 		return err
 	}
-	treeTempl, err := template.Must(baseTempl.ParseFS(templates, branchPath)).Funcs(fileFuncMap).ParseFS(templates, treePath)
+	treeTempl, err := template.Must(baseTempl.ParseFS(templates, branchPath)).Funcs(fileFuncMap).ParseFS(templates, treePath, symlinkPath)
 	if err != nil {
 		return err
 	}
@@ -355,20 +480,26 @@ func generateIndex(branch *object.Commit, submoduleMap map[string]string, treePr
 	return err
 }
 
-func generateLog(branch *object.Commit, refs map[plumbing.Hash][]ShortRef, base BaseData, buffer *bytes.Buffer, logLimit uint) error {
+func generateLog(branch *object.Commit, verifier *Verifier, index *RepoIndex, autolinks []AutolinkRule, base BaseData, buffer *bytes.Buffer, logLimit uint) error {
 	var logData LogData
-	logData.fromBranchAndRefs(branch, refs, logLimit)
+	err := logData.fromBranchAndRefs(branch, index.refs, verifier, logLimit)
+	if err != nil {
+		return err
+	}
 
 	partialsPath := filepath.Join("templates", "partials")
 	basePath := filepath.Join("templates", "base.html")
 	navPath := filepath.Join(partialsPath, "nav.html")
 	logPath := filepath.Join(partialsPath, "content", "log.html")
+	logTablePath := filepath.Join(partialsPath, "logtable.html")
+	signaturePath := filepath.Join(partialsPath, "signature.html")
 	footPath := filepath.Join(partialsPath, "footer.html")
 	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
 	if err != nil {
 		return err
 	}
-	logTempl, err := baseTempl.Funcs(refFuncMap).ParseFS(templates, logPath)
+	funcs := autolinkFuncMap(index, autolinks, base.Root)
+	logTempl, err := baseTempl.Funcs(refFuncMap).Funcs(signatureFuncMap).Funcs(funcs).ParseFS(templates, logPath, logTablePath, signaturePath)
 	if err != nil {
 		return err
 	}
@@ -2,8 +2,11 @@ package views
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
+	"mime"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -23,15 +26,34 @@ type CommitData struct {
 	Note      string
 	Author    AuthorData
 	Committer AuthorData
+	Trailers  []TrailerData
 	Parents   []plumbing.Hash
 	Notes     []NoteData
 	Hash      plumbing.Hash
 	Stats     object.FileStats
 	Lines     Diff
+	// Where the lines of the diff link to, which is empty when the files aren't rendered
+	Files     string
+	Signature SignatureData
+	// The branches and tags containing the commit
+	Containing []ShortRef
+	// The neighbouring commits along the first-parent chain
+	Previous plumbing.Hash
+	Next     plumbing.Hash
+}
+
+// Trailers whose value is a person, e.g., Signed-off-by, have it split into name and email
+type TrailerData struct {
+	Key   string
+	Value string
+	Name  string
+	Email string
 }
 
 type NoteData struct {
 	Reference string
+	// The reference without refs/notes/, e.g., commits or review
+	Namespace string
 	Time      time.Time
 	Hash      plumbing.Hash
 	Blob      BlobData
@@ -39,6 +61,60 @@ type NoteData struct {
 
 type NoteMap = map[string][]NoteData
 
+var (
+	trailerRegexp = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*):[ \t]+(\S.*)$`)
+	personRegexp  = regexp.MustCompile(`^(.*?)\s*<([^<>]+)>$`)
+)
+
+// Like git interpret-trailers, trailers are the last paragraph of a message when it
+// isn't the subject and every line is either a trailer or a continuation of one.
+func parseTrailers(message string) (string, []TrailerData) {
+	trimmed := strings.TrimRight(message, "\n")
+	split := strings.LastIndex(trimmed, "\n\n")
+	if split == -1 {
+		return message, nil
+	}
+
+	trailers := make([]TrailerData, 0)
+	for _, line := range strings.Split(trimmed[split+2:], "\n") {
+		if len(trailers) != 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			trailers[len(trailers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		match := trailerRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return message, nil
+		}
+		trailers = append(trailers, TrailerData{Key: match[1], Value: match[2]})
+	}
+
+	for idx := range trailers {
+		if person := personRegexp.FindStringSubmatch(trailers[idx].Value); person != nil {
+			trailers[idx].Name = person[1]
+			trailers[idx].Email = person[2]
+		}
+	}
+	return trimmed[:split], trailers
+}
+
+// Co-authors are credited by name, or email when the trailer has no name
+func coAuthors(trailers []TrailerData) []string {
+	names := make([]string, 0)
+	for _, trailer := range trailers {
+		if !strings.EqualFold(trailer.Key, "Co-authored-by") {
+			continue
+		}
+		if trailer.Name != "" {
+			names = append(names, trailer.Name)
+		} else if trailer.Email != "" {
+			names = append(names, trailer.Email)
+		} else {
+			names = append(names, trailer.Value)
+		}
+	}
+	return names
+}
+
 func recentNoteTime(notes []NoteData) time.Time {
 	var recent time.Time
 	for _, note := range notes {
@@ -55,12 +131,17 @@ func (data *AuthorData) fromSignature(signature *object.Signature) {
 	data.Date = signature.When
 }
 
-func (data *CommitData) fromCommit(commit *object.Commit) error {
+func (data *CommitData) fromCommit(commit *object.Commit, changes object.Changes, patch *object.Patch, verifier *Verifier, index *RepoIndex, config Config) error {
 	data.Parents = commit.ParentHashes
+	if len(commit.ParentHashes) != 0 {
+		data.Previous = commit.ParentHashes[0]
+	}
 	data.Author.fromSignature(&commit.Author)
 	data.Committer.fromSignature(&commit.Committer)
 	data.Hash = commit.Hash
-	splitHeadAndBody := strings.Split(commit.Message, "\n\n")
+	message, trailers := parseTrailers(commit.Message)
+	data.Trailers = trailers
+	splitHeadAndBody := strings.Split(message, "\n\n")
 	data.Head = splitHeadAndBody[0]
 	if len(data.Head) > 50 {
 		data.Message = strings.Join(splitHeadAndBody[:], "\n\n")
@@ -68,52 +149,129 @@ func (data *CommitData) fromCommit(commit *object.Commit) error {
 		data.Message = strings.Join(splitHeadAndBody[1:], "\n\n")
 	}
 
-	// TODO: We should combine diffs for a merge. How should this be done?
-	patch, err := patchFromCommit(commit)
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	attributes, err := attributesForPaths(tree, patchPaths(patch))
+	if err != nil {
+		return err
+	}
+	submodules, err := newSubmoduleLinker(tree, index, config.SubmoduleSites)
 	if err != nil {
 		return err
 	}
 	data.Stats = patch.Stats()
-	data.Lines = makeDiff(patch)
+	data.Lines = makeDiff(changes, patch, attributes, submodules, config.PathFilter, config.DiffFileLimit, config.DiffCommitLimit)
+
+	data.Signature, err = verifier.verifyCommit(commit)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func patchFromCommit(commit *object.Commit) (*object.Patch, error) {
+func patchFromCommit(commit *object.Commit) (object.Changes, *object.Patch, error) {
 	var pTree *object.Tree = nil
 	parent, err := commit.Parent(0)
 	if err == nil {
 		pTree, err = parent.Tree()
 	} else if err != object.ErrParentNotFound {
-		return nil, err
+		return nil, nil, err
 	}
 	cTree, err := commit.Tree()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	changes, err := pTree.Diff(cTree)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	patch, err := changes.Patch()
+	return changes, patch, err
+}
+
+// The output follows git format-patch so that it can be applied with git am
+func formatPatch(commit *object.Commit, patch *object.Patch, buffer *bytes.Buffer) error {
+	paragraphs := strings.SplitN(strings.TrimSpace(commit.Message), "\n\n", 2)
+	subject := strings.Join(strings.Fields(paragraphs[0]), " ")
+
+	fmt.Fprintf(buffer, "From %s Mon Sep 17 00:00:00 2001\n", commit.Hash)
+	// Non-ascii headers have to be encoded for git am to read them back
+	fmt.Fprintf(buffer, "From: %s <%s>\n", mime.QEncoding.Encode("utf-8", commit.Author.Name), commit.Author.Email)
+	fmt.Fprintf(buffer, "Date: %s\n", commit.Author.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(buffer, "Subject: [PATCH] %s\n\n", mime.QEncoding.Encode("utf-8", subject))
+	if len(paragraphs) > 1 {
+		fmt.Fprintf(buffer, "%s\n", strings.TrimSpace(paragraphs[1]))
+	}
+	buffer.WriteString("---\n")
+
+	stats := patch.Stats()
+	additions, deletions := 0, 0
+	for _, stat := range stats {
+		additions += stat.Addition
+		deletions += stat.Deletion
+	}
+	buffer.WriteString(stats.String())
+	buffer.WriteString(statSummary(len(stats), additions, deletions) + "\n\n")
+
+	err := patch.Encode(buffer)
+	if err != nil {
+		return err
 	}
-	return changes.Patch()
+	buffer.WriteString("-- \ngit-to-html\n\n")
+	return nil
 }
 
-func generateCommit(commit *object.Commit, notes []NoteData, base BaseData, buffer *bytes.Buffer) error {
-	var data CommitData
-	err := data.fromCommit(commit)
-	data.Notes = notes
+// The summary line of git diff --stat, which only leaves out a zero count of insertions
+// or deletions when the other isn't zero
+func statSummary(files int, additions int, deletions int) string {
+	plural := func(count int, singular string, multiple string) string {
+		if count == 1 {
+			return fmt.Sprintf("%d %s", count, singular)
+		}
+		return fmt.Sprintf("%d %s", count, multiple)
+	}
+	summary := " " + plural(files, "file changed", "files changed")
+	if files == 0 {
+		return summary
+	}
+	if additions != 0 || deletions == 0 {
+		summary += ", " + plural(additions, "insertion(+)", "insertions(+)")
+	}
+	if deletions != 0 || additions == 0 {
+		summary += ", " + plural(deletions, "deletion(-)", "deletions(-)")
+	}
+	return summary
+}
+
+func patchPaths(patch *object.Patch) []string {
+	paths := make([]string, 0)
+	for _, filePatch := range patch.FilePatches() {
+		if path := filePatchPath(filePatch); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
 
+func generateCommit(data CommitData, index *RepoIndex, autolinks []AutolinkRule, base BaseData, buffer *bytes.Buffer) error {
 	partialsPath := filepath.Join("templates", "partials")
 	basePath := filepath.Join("templates", "base.html")
 	navPath := filepath.Join(partialsPath, "nav.html")
 	commitPath := filepath.Join(partialsPath, "content", "commit.html")
 	blobPath := filepath.Join(partialsPath, "blob.html") // Notes are blobs
+	signaturePath := filepath.Join(partialsPath, "signature.html")
+	diffPath := filepath.Join(partialsPath, "diff.html")
+	notesPath := filepath.Join(partialsPath, "notes.html")
 	footPath := filepath.Join(partialsPath, "footer.html")
 	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
 	if err != nil {
 		return nil
 	}
-	commitTempl, err := template.Must(baseTempl.ParseFS(templates, commitPath)).ParseFS(templates, blobPath)
+	funcs := autolinkFuncMap(index, autolinks, base.Root)
+	commitTempl, err := baseTempl.Funcs(diffFuncMap).Funcs(signatureFuncMap).Funcs(refFuncMap).Funcs(noteFuncMap).Funcs(funcs).ParseFS(templates, commitPath, blobPath, signaturePath, diffPath, notesPath)
 	if err != nil {
 		return nil
 	}
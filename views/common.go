@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -14,16 +15,36 @@ import (
 
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/microcosm-cc/bluemonday"
-	blackfriday "github.com/russross/blackfriday/v2"
+	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
 //go:embed templates templates/partials templates/partials/content
 var templates embed.FS
 
 type Config struct {
-	LogLimit  uint
-	StylePath string
+	LogLimit        uint
+	StylePath       string
+	DiffFileLimit   uint
+	DiffCommitLimit uint
+	CommitFiles     bool
+	GPGKeyring      string
+	AllowedSigners  string
+	Autolinks       []AutolinkRule
+	Compares        []ComparePair
+	CompareDefault  bool
+	CompareTags     bool
+	// Render remote-tracking branches and the refs under each namespace, e.g., refs/pull,
+	// as branches when their full names pass RefFilter
+	Remotes    bool
+	Namespaces []string
+	RefFilter  Filter
+	// Local branches are matched by their short names and paths by their full path in the tree
+	BranchFilter Filter
+	PathFilter   Filter
+	// Sites of submodules generated alongside this repository
+	SubmoduleSites []SubmoduleSite
+	// Markdown within a tree with at least this many headings gets a table of contents
+	TocHeadings uint
 }
 
 type BaseData struct {
@@ -38,6 +59,13 @@ type BaseData struct {
 type NavData struct {
 	Commit string
 	Branch string
+	// Tree and blob pages lead back up to the top of their tree
+	Breadcrumbs []Breadcrumb
+}
+
+type Breadcrumb struct {
+	Name string
+	Href string
 }
 
 type FileMode int8
@@ -78,19 +106,28 @@ func writeHtml(buffer *bytes.Buffer, path string) error {
 	return err
 }
 
-func mdToHtml(markdownFile *object.File) (template.HTML, error) {
-	reader, err := markdownFile.Reader()
+func writeRaw(file *object.File, path string) (err error) {
+	err = os.MkdirAll(filepath.Dir(path), 0755)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer reader.Close()
-
-	buffer := new(bytes.Buffer)
-	buffer.ReadFrom(reader)
-	unsafe := blackfriday.Run(buffer.Bytes())
-	html := bluemonday.UGCPolicy().SanitizeBytes(unsafe)
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(reader, &err)
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(output, &err)
+	_, err = io.Copy(output, reader)
+	return err
+}
 
-	return template.HTML(html[:]), nil
+func mdBytesToHtml(markdown []byte, links *markdownLinks) template.HTML {
+	unsafe := renderMarkdown(markdown, links)
+	return sanitizeMarkdown(unsafe)
 }
 
 func prettifyBytes(size int64) string {
@@ -119,3 +156,23 @@ func relRootFromPath(path string) string {
 	root := strings.Repeat("../", depth)
 	return root
 }
+
+// The trail runs from the home page through the top of the tree, whose page is top relative
+// to the tree's pages, down to name, where directories only have pages if linkDirs is set
+func treeBreadcrumbs(home string, root string, topName string, top string, name string, linkDirs bool) []Breadcrumb {
+	crumbs := []Breadcrumb{{Name: home, Href: root + "index.html"}}
+	if name == "" {
+		return append(crumbs, Breadcrumb{Name: topName})
+	}
+	treeBase := strings.Repeat("../", strings.Count(name, "/"))
+	crumbs = append(crumbs, Breadcrumb{Name: topName, Href: treeBase + top})
+	parts := strings.Split(name, "/")
+	for idx, part := range parts {
+		crumb := Breadcrumb{Name: part}
+		if linkDirs && idx < len(parts)-1 {
+			crumb.Href = treeBase + treePage(strings.Join(parts[:idx+1], "/"))
+		}
+		crumbs = append(crumbs, crumb)
+	}
+	return crumbs
+}
@@ -0,0 +1,177 @@
+package views
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Either side may be anything git rev-parse understands, e.g., a branch, tag or hash
+type ComparePair struct {
+	Base string
+	Head string
+	// The commits tags were peeled to, which are zero when the side has to be resolved
+	baseHash plumbing.Hash
+	headHash plumbing.Hash
+}
+
+type CompareData struct {
+	Base      string
+	Head      string
+	BaseHash  plumbing.Hash
+	HeadHash  plumbing.Hash
+	MergeBase plumbing.Hash
+	Ahead     int
+	Behind    int
+	Stats     object.FileStats
+	Lines     Diff
+	// Where the lines of the diff link to, which is empty when head has no tree pages
+	Files string
+	Raw   string
+}
+
+const compareDir = "compare"
+
+// Relative to the output root, like git the three dots mean the changes on head since
+// it diverged from base. The revisions are escaped like names in a tree as they may be
+// tags or branches holding anything a ref can, and a slash within them is escaped too.
+func comparePath(pair ComparePair) string {
+	return filepath.Join(compareDir, escapeTreeName(pair.Base)+"..."+escapeTreeName(pair.Head)+".html")
+}
+
+// The configured pairs followed by each branch against the default branch and each
+// tag against the previous one when enabled
+func comparePairs(repository *git.Repository, index *RepoIndex, tags TagDataSlice, config Config) ([]ComparePair, error) {
+	pairs := make([]ComparePair, 0, len(config.Compares))
+	pairs = append(pairs, config.Compares...)
+
+	if config.CompareDefault && index.defaultBranch != nil {
+		branchIter, err := repository.Branches()
+		if err != nil {
+			return nil, err
+		}
+		defer branchIter.Close()
+		defaultName := index.defaultBranch.Name().Short()
+		err = branchIter.ForEach(func(branch *plumbing.Reference) error {
+			if branch.Name() != index.defaultBranch.Name() && config.BranchFilter.Allows(branch.Name().Short()) {
+				pairs = append(pairs, ComparePair{Base: defaultName, Head: branch.Name().Short()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CompareTags {
+		for idx, tag := range tags {
+			if previous := tags.previous(idx); previous != nil && !tag.Target.IsZero() {
+				pairs = append(pairs, ComparePair{Base: previous.Name, Head: tag.Name, baseHash: previous.Target, headHash: tag.Target})
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// go-git's ResolveRevision gives up on a tag pointing to another tag, so a tag which
+// doesn't resolve is peeled like the tags on the refs page
+func resolveCompared(repository *git.Repository, revision string, peeled plumbing.Hash) (*object.Commit, error) {
+	if peeled.IsZero() {
+		hash, err := repository.ResolveRevision(plumbing.Revision(revision))
+		if err == nil {
+			peeled = *hash
+		} else if tag, tagErr := repository.Tag(revision); tagErr == nil {
+			object, tagErr := repository.TagObject(tag.Hash())
+			if tagErr != nil {
+				return nil, fmt.Errorf("resolving %s: %w", revision, err)
+			}
+			peeled, _, err = peelTag(object, repository)
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s: %w", revision, err)
+			}
+		} else {
+			return nil, fmt.Errorf("resolving %s: %w", revision, err)
+		}
+	}
+	return repository.CommitObject(peeled)
+}
+
+func (data *CompareData) fromCommits(base *object.Commit, head *object.Commit, index *RepoIndex, config Config) (*object.Patch, error) {
+	data.BaseHash = base.Hash
+	data.HeadHash = head.Hash
+	data.Ahead, data.Behind = index.aheadBehind(base.Hash, head.Hash)
+
+	// Unrelated histories are compared against the empty tree
+	var baseTree *object.Tree = nil
+	mergeBases, err := head.MergeBase(base)
+	if err != nil {
+		return nil, err
+	}
+	if len(mergeBases) != 0 {
+		data.MergeBase = mergeBases[0].Hash
+		baseTree, err = mergeBases[0].Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := baseTree.Diff(headTree)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := attributesForPaths(headTree, patchPaths(patch))
+	if err != nil {
+		return nil, err
+	}
+	submodules, err := newSubmoduleLinker(headTree, index, config.SubmoduleSites)
+	if err != nil {
+		return nil, err
+	}
+	data.Stats = patch.Stats()
+	data.Lines = makeDiff(changes, patch, attributes, submodules, config.PathFilter, config.DiffFileLimit, config.DiffCommitLimit)
+	return patch, nil
+}
+
+func generateCompare(data CompareData, logData LogData, index *RepoIndex, autolinks []AutolinkRule, base BaseData, buffer *bytes.Buffer) error {
+	partialsPath := filepath.Join("templates", "partials")
+	basePath := filepath.Join("templates", "base.html")
+	navPath := filepath.Join(partialsPath, "nav.html")
+	comparePath := filepath.Join(partialsPath, "content", "compare.html")
+	logTablePath := filepath.Join(partialsPath, "logtable.html")
+	signaturePath := filepath.Join(partialsPath, "signature.html")
+	diffPath := filepath.Join(partialsPath, "diff.html")
+	footPath := filepath.Join(partialsPath, "footer.html")
+	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
+	if err != nil {
+		return err
+	}
+	funcs := autolinkFuncMap(index, autolinks, base.Root)
+	compareTempl, err := baseTempl.Funcs(diffFuncMap).Funcs(refFuncMap).Funcs(signatureFuncMap).Funcs(funcs).ParseFS(templates, comparePath, logTablePath, signaturePath, diffPath)
+	if err != nil {
+		return err
+	}
+
+	err = compareTempl.Execute(buffer, struct {
+		Compare CompareData
+		Log     LogData
+		BaseData
+	}{
+		data,
+		logData,
+		base,
+	})
+	return err
+}
@@ -6,11 +6,14 @@ package views
 
 import (
 	"fmt"
+	"html/template"
 	"regexp"
 	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
@@ -26,11 +29,45 @@ const (
 	Frag
 	Old
 	New
+	Omit
 )
 
+// This global is treated as a constant and should only be read
+// It acts as a string mapping for the diff enums the templates need to tell apart
+var diffFuncMap = template.FuncMap{
+	"Omitted":  func() DiffType { return Omit },
+	"DiffView": newDiffView,
+	"TreePage": treePage,
+}
+
+// What the diff partial renders, where raw links to the full patch and files is the
+// prefix for the pages of the new files, leaving line numbers unlinked when empty
+type DiffView struct {
+	Lines Diff
+	Raw   string
+	Files string
+	Root  string
+}
+
+func newDiffView(lines Diff, raw string, files string, root string) DiffView {
+	return DiffView{lines, raw, files, root}
+}
+
+// Lines of a hunk carry their line numbers in the old and new file (0 when the line
+// is absent from that side) along with the new path when it can be linked to.
 type DiffBlock struct {
-	Type DiffType
-	Text string
+	Type    DiffType
+	Text    string
+	OldLine int
+	NewLine int
+	Path    string
+	// Submodule commits link to their page, which may be relative to the output root
+	Href  string
+	Local bool
+}
+
+func (self DiffBlock) IsLine() bool {
+	return self.OldLine != 0 || self.NewLine != 0
 }
 
 type Diff = []DiffBlock
@@ -58,34 +95,28 @@ func (self *DiffBuilder) Append(blocks ...DiffBlock) {
 	self.queue = append(self.queue, blocks...)
 }
 
+// Consecutive blocks of the same type are merged unless they are numbered lines or links
 func (self *DiffBuilder) Diff() Diff {
 	diff := make([]DiffBlock, 0)
-	if len(self.queue) != 0 {
-		var sb strings.Builder
-		var currentType DiffType = self.queue[0].Type
-		for _, block := range self.queue {
-			text := block.Text
-			if block.Type != currentType {
-				newBlock := DiffBlock{
-					Type: currentType,
-					Text: sb.String(),
-				}
-				diff = append(diff, newBlock)
-				sb.Reset()
-				currentType = block.Type
+	var sb strings.Builder
+	for idx, block := range self.queue {
+		sb.WriteString(block.Text)
+		if idx+1 < len(self.queue) {
+			next := self.queue[idx+1]
+			if next.Type == block.Type && !next.IsLine() && !block.IsLine() && next.Href == "" && block.Href == "" {
+				continue
 			}
-			sb.WriteString(text)
-		}
-		block := DiffBlock{
-			Type: currentType,
-			Text: sb.String(),
 		}
+		block.Text = sb.String()
 		diff = append(diff, block)
+		sb.Reset()
 	}
 	return diff
 }
 
-func makeDiff(patch *object.Patch) Diff {
+// Limits are counted in changed lines with 0 giving no limit. Once the commit limit
+// is reached every remaining file is summarised rather than rendered.
+func makeDiff(changes object.Changes, patch *object.Patch, attributes gitattributes.Matcher, submodules *submoduleLinker, pathFilter Filter, fileLimit uint, commitLimit uint) Diff {
 	db := NewDiffBuilder()
 
 	message := patch.Message()
@@ -96,12 +127,34 @@ func makeDiff(patch *object.Patch) Diff {
 		db.Add(Meta, message)
 	}
 
-	for _, filePatch := range patch.FilePatches() {
+	var commitLines uint = 0
+	for idx, filePatch := range patch.FilePatches() {
+		if change := submoduleChange(changes, idx, filePatch); change != nil {
+			db.Append(submoduleBlocks(change, submodules)...)
+			continue
+		}
 		header := makeDiffHeader(filePatch)
 		db.Add(Meta, header)
+
+		fileLines := changedLines(filePatch)
+		reason := suppressReason(filePatch, attributes)
+		if reason == "" && fileLimit != 0 && fileLines > fileLimit {
+			reason = fmt.Sprintf("%d changed lines exceeds the per-file limit of %d", fileLines, fileLimit)
+		}
+		if reason == "" && commitLimit != 0 && commitLines+fileLines > commitLimit {
+			reason = fmt.Sprintf("commit diff exceeds the limit of %d changed lines", commitLimit)
+			// Don't let a smaller file after this one sneak in under the limit
+			commitLines = commitLimit
+		}
+		if reason != "" {
+			db.Add(Omit, fmt.Sprintf("Diff suppressed: %s.", reason))
+			continue
+		}
+		commitLines += fileLines
+
 		g := newHunksGenerator(filePatch.Chunks())
 		for _, hunk := range g.Generate() {
-			blocks := hunk.Blocks()
+			blocks := hunk.Blocks(linkablePath(filePatch, pathFilter))
 			db.Append(blocks...)
 		}
 	}
@@ -109,6 +162,132 @@ func makeDiff(patch *object.Patch) Diff {
 	return db.Diff()
 }
 
+// go-git leaves submodules out of file patches, so they are found from the change at the
+// same position, which the patch was made from
+func submoduleChange(changes object.Changes, idx int, filePatch diff.FilePatch) *object.Change {
+	from, to := filePatch.Files()
+	if from != nil || to != nil || idx >= len(changes) {
+		return nil
+	}
+	change := changes[idx]
+	if change.From.TreeEntry.Mode != filemode.Submodule && change.To.TreeEntry.Mode != filemode.Submodule {
+		return nil
+	}
+	return change
+}
+
+// Submodule bumps are shown as git does with a line per pinned commit, each linking to
+// the commit's page when the submodule's URL is known
+func submoduleBlocks(change *object.Change, submodules *submoduleLinker) []DiffBlock {
+	from, to := change.From, change.To
+	hasFrom := from.TreeEntry.Mode == filemode.Submodule
+	hasTo := to.TreeEntry.Mode == filemode.Submodule
+	name := to.Name
+	if !hasTo {
+		name = from.Name
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", name, name)
+	switch {
+	case !hasFrom:
+		fmt.Fprintf(&sb, "new file mode %o\n", to.TreeEntry.Mode)
+		fmt.Fprintf(&sb, "index %.7s..%.7s\n", plumbing.ZeroHash, to.TreeEntry.Hash)
+		appendPathLines(&sb, "/dev/null", "b/"+name, false)
+	case !hasTo:
+		fmt.Fprintf(&sb, "deleted file mode %o\n", from.TreeEntry.Mode)
+		fmt.Fprintf(&sb, "index %.7s..%.7s\n", from.TreeEntry.Hash, plumbing.ZeroHash)
+		appendPathLines(&sb, "a/"+name, "/dev/null", false)
+	default:
+		fmt.Fprintf(&sb, "index %.7s..%.7s %o\n", from.TreeEntry.Hash, to.TreeEntry.Hash, to.TreeEntry.Mode)
+		appendPathLines(&sb, "a/"+name, "b/"+name, false)
+	}
+	blocks := []DiffBlock{{Type: Meta, Text: sb.String()}}
+
+	switch {
+	case !hasFrom:
+		blocks = append(blocks, DiffBlock{Type: Frag, Text: "@@ -0,0 +1 @@\n"})
+	case !hasTo:
+		blocks = append(blocks, DiffBlock{Type: Frag, Text: "@@ -1 +0,0 @@\n"})
+	default:
+		blocks = append(blocks, DiffBlock{Type: Frag, Text: "@@ -1 +1 @@\n"})
+	}
+	if hasFrom {
+		href, local := submodules.link(from.Name, from.TreeEntry.Hash)
+		blocks = append(blocks, DiffBlock{Type: Old, Text: fmt.Sprintf("-Subproject commit %s\n", from.TreeEntry.Hash), Href: href, Local: local})
+	}
+	if hasTo {
+		href, local := submodules.link(to.Name, to.TreeEntry.Hash)
+		blocks = append(blocks, DiffBlock{Type: New, Text: fmt.Sprintf("+Subproject commit %s\n", to.TreeEntry.Hash), Href: href, Local: local})
+	}
+	return blocks
+}
+
+func changedLines(filePatch diff.FilePatch) uint {
+	var count uint = 0
+	for _, chunk := range filePatch.Chunks() {
+		if chunk.Type() != diff.Equal {
+			count += uint(len(splitLines(chunk.Content())))
+		}
+	}
+	return count
+}
+
+func filePatchPath(filePatch diff.FilePatch) string {
+	from, to := filePatch.Files()
+	if to != nil {
+		return to.Path()
+	} else if from != nil {
+		return from.Path()
+	}
+	return ""
+}
+
+// Only text files which exist after the patch and aren't filtered out have a page to link lines to
+func linkablePath(filePatch diff.FilePatch, pathFilter Filter) string {
+	_, to := filePatch.Files()
+	if to == nil || filePatch.IsBinary() || to.Mode() == filemode.Submodule || !pathFilter.AllowsPath(to.Path(), false) {
+		return ""
+	}
+	return to.Path()
+}
+
+// The paths which have numbered lines linking to them in the diff
+func linkedPaths(lines Diff) []string {
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, block := range lines {
+		if block.Path != "" && !seen[block.Path] {
+			seen[block.Path] = true
+			paths = append(paths, block.Path)
+		}
+	}
+	return paths
+}
+
+// Files marked as generated for linguist or with diff unset are summarised like git
+// would for the latter, regardless of size.
+func suppressReason(filePatch diff.FilePatch, attributes gitattributes.Matcher) string {
+	if attributes == nil || filePatch.IsBinary() {
+		return ""
+	}
+	path := filePatchPath(filePatch)
+	if path == "" {
+		return ""
+	}
+
+	results, _ := attributes.Match(strings.Split(path, "/"), []string{"linguist-generated", "diff"})
+	if generated, ok := results["linguist-generated"]; ok {
+		if generated.IsSet() || (generated.IsValueSet() && generated.Value() == "true") {
+			return "generated file"
+		}
+	}
+	if diffAttribute, ok := results["diff"]; ok && diffAttribute.IsUnset() {
+		return "diff is unset in .gitattributes"
+	}
+	return ""
+}
+
 func appendPathLines(sb *strings.Builder, fromPath string, toPath string, isBinary bool) {
 	if isBinary {
 		fmt.Fprintf(sb, "Binary files %s and %s differ\n", fromPath, toPath)
@@ -303,7 +482,7 @@ type hunk struct {
 	ops       []*op
 }
 
-func (h *hunk) Blocks() []DiffBlock {
+func (h *hunk) Blocks(path string) []DiffBlock {
 	var sb strings.Builder
 	Blocks := make([]DiffBlock, 0)
 
@@ -339,8 +518,25 @@ func (h *hunk) Blocks() []DiffBlock {
 		Text: sb.String() + "\n",
 	})
 
+	oldLine, newLine := h.fromLine, h.toLine
 	for _, op := range h.ops {
 		block := op.Block()
+		switch op.t {
+		case diff.Add:
+			block.NewLine = newLine
+			newLine++
+		case diff.Delete:
+			block.OldLine = oldLine
+			oldLine++
+		case diff.Equal:
+			block.OldLine = oldLine
+			block.NewLine = newLine
+			oldLine++
+			newLine++
+		}
+		if block.NewLine != 0 {
+			block.Path = path
+		}
 		Blocks = append(Blocks, block)
 	}
 	return Blocks
@@ -0,0 +1,387 @@
+package views
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type DocumentFormat int8
+
+const (
+	MARKDOWN_E DocumentFormat = iota
+	RST_E
+	ORG_E
+	TEXT_E
+)
+
+// Documents with these extensions are rendered on their blob pages as well as shown as source
+var documentExtensions = map[string]DocumentFormat{
+	".md":       MARKDOWN_E,
+	".markdown": MARKDOWN_E,
+	".mdown":    MARKDOWN_E,
+	".rst":      RST_E,
+	".org":      ORG_E,
+}
+
+// When a directory has more than one README, the first of these is shown
+var readmeNames = []string{
+	"readme.md",
+	"readme.markdown",
+	"readme.mdown",
+	"readme.rst",
+	"readme.org",
+	"readme.txt",
+	"readme",
+}
+
+var readmeFormats = map[string]DocumentFormat{
+	"readme.txt": TEXT_E,
+	// READMEs without an extension have always been rendered as markdown
+	"readme": MARKDOWN_E,
+}
+
+func documentFormat(name string) (DocumentFormat, bool) {
+	format, ok := documentExtensions[strings.ToLower(path.Ext(name))]
+	return format, ok
+}
+
+// The lower the rank, the higher the README's priority
+func readmeRank(name string) (DocumentFormat, int, bool) {
+	lower := strings.ToLower(name)
+	for rank, readme := range readmeNames {
+		if lower != readme {
+			continue
+		}
+		if format, ok := readmeFormats[lower]; ok {
+			return format, rank, true
+		}
+		format, _ := documentFormat(lower)
+		return format, rank, true
+	}
+	return 0, 0, false
+}
+
+// reStructuredText and Org documents are translated to markdown, so they share its links and
+// sanitizing, while plain text is shown as it is
+func documentToHtml(file *object.File, format DocumentFormat, links *markdownLinks) (template.HTML, error) {
+	contents, err := file.Contents()
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case RST_E:
+		contents = rstToMarkdown(contents)
+	case ORG_E:
+		contents = orgToMarkdown(contents)
+	case TEXT_E:
+		return template.HTML("<pre>" + html.EscapeString(contents) + "</pre>"), nil
+	}
+	return mdBytesToHtml([]byte(contents), links), nil
+}
+
+func documentLines(source string) []string {
+	return strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// The indented block starting at start, skipping blank lines before it, dedented by its
+// least indented line, along with the index of the line after it
+func indentedBlock(lines []string, start int) ([]string, int) {
+	first := start
+	for first < len(lines) && strings.TrimSpace(lines[first]) == "" {
+		first++
+	}
+	if first == len(lines) || !isIndented(lines[first]) {
+		return nil, start
+	}
+	end := first
+	indent := -1
+	for end < len(lines) && (strings.TrimSpace(lines[end]) == "" || isIndented(lines[end])) {
+		if trimmed := strings.TrimLeft(lines[end], " \t"); trimmed != "" {
+			if width := len(lines[end]) - len(trimmed); indent == -1 || width < indent {
+				indent = width
+			}
+		}
+		end++
+	}
+	for end > first && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	block := make([]string, 0, end-first)
+	for _, line := range lines[first:end] {
+		if len(line) >= indent {
+			line = line[indent:]
+		}
+		block = append(block, line)
+	}
+	return block, end
+}
+
+var markdownListItemPattern = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s`)
+
+// Blackfriday takes a fence right after a list into its last item as inline code, so an
+// empty comment ends the list first when the output so far ends in an item or is indented
+// like the rest of one
+func appendFenced(output []string, language string, block []string) []string {
+	for idx := len(output) - 1; idx >= 0; idx-- {
+		if strings.TrimSpace(output[idx]) == "" {
+			continue
+		}
+		if markdownListItemPattern.MatchString(output[idx]) || isIndented(output[idx]) {
+			output = append(output, "", "<!-- -->")
+		}
+		break
+	}
+	output = append(output, "", "```"+language)
+	output = append(output, block...)
+	return append(output, "```", "")
+}
+
+// Headings are numbered by the order their style first appears in, as in reStructuredText
+func headingLevel(styles *[]string, style string) int {
+	for idx, seen := range *styles {
+		if seen == style {
+			return min(idx+1, 6)
+		}
+	}
+	*styles = append(*styles, style)
+	return min(len(*styles), 6)
+}
+
+const rstAdornments = "=-`:'\"~^_*+#<>."
+
+func isRstAdornment(line string) bool {
+	line = strings.TrimRight(line, " ")
+	if len(line) < 2 || !strings.ContainsRune(rstAdornments, rune(line[0])) {
+		return false
+	}
+	return strings.Count(line, line[:1]) == len(line)
+}
+
+var (
+	rstTargetPattern      = regexp.MustCompile("^\\.\\. _([^:]+): *(\\S+)\\s*$")
+	rstDirectivePattern   = regexp.MustCompile("^\\.\\. +([\\w-]+):: *(.*)$")
+	rstLiteralPattern     = regexp.MustCompile("``(.+?)``")
+	rstLinkPattern        = regexp.MustCompile("`([^`<]+?) *<([^`>]+)>`__?")
+	rstReferencePattern   = regexp.MustCompile("`([^`]+)`__?")
+	rstRolePattern        = regexp.MustCompile(":([\\w-]+):`([^`]+)`")
+	rstInterpretedPattern = regexp.MustCompile("`([^`]+)`")
+)
+
+// Only the parts of reStructuredText which READMEs commonly use are translated: section
+// titles, literal and code blocks, images, links and inline markup. Lists and emphasis
+// are already markdown, other directives are quoted and comments are dropped.
+func rstToMarkdown(source string) string {
+	lines := documentLines(source)
+	targets := make(map[string]string)
+	for _, line := range lines {
+		if match := rstTargetPattern.FindStringSubmatch(line); match != nil {
+			targets[strings.ToLower(match[1])] = match[2]
+		}
+	}
+	inline := func(line string) string {
+		// Code is set aside so that nothing within it is taken as markup
+		var literals []string
+		setAside := func(code string) string {
+			literals = append(literals, "`"+code+"`")
+			return fmt.Sprintf("\x00%d\x00", len(literals)-1)
+		}
+		line = rstLiteralPattern.ReplaceAllStringFunc(line, func(literal string) string {
+			return setAside(strings.Trim(literal, "`"))
+		})
+		line = rstLinkPattern.ReplaceAllString(line, "[$1]($2)")
+		line = rstReferencePattern.ReplaceAllStringFunc(line, func(reference string) string {
+			name := rstReferencePattern.FindStringSubmatch(reference)[1]
+			if target, ok := targets[strings.ToLower(name)]; ok {
+				return "[" + name + "](" + target + ")"
+			}
+			return name
+		})
+		line = rstRolePattern.ReplaceAllStringFunc(line, func(role string) string {
+			match := rstRolePattern.FindStringSubmatch(role)
+			switch match[1] {
+			case "code", "literal", "file", "command", "samp":
+				return setAside(match[2])
+			}
+			return match[2]
+		})
+		line = rstInterpretedPattern.ReplaceAllString(line, "*$1*")
+		for idx, literal := range literals {
+			line = strings.Replace(line, fmt.Sprintf("\x00%d\x00", idx), literal, 1)
+		}
+		return line
+	}
+
+	var styles []string
+	var output []string
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		trimmed := strings.TrimSpace(line)
+
+		// Titles may be over and underlined, or only underlined at least as long as the title
+		if isRstAdornment(line) && idx+2 < len(lines) && strings.TrimSpace(lines[idx+1]) != "" &&
+			isRstAdornment(lines[idx+2]) && lines[idx+2][0] == line[0] {
+			level := headingLevel(&styles, "over"+line[:1])
+			output = append(output, "", strings.Repeat("#", level)+" "+inline(strings.TrimSpace(lines[idx+1])), "")
+			idx += 2
+			continue
+		}
+		if trimmed != "" && !isIndented(line) && idx+1 < len(lines) && isRstAdornment(lines[idx+1]) &&
+			len(strings.TrimRight(lines[idx+1], " ")) >= utf8.RuneCountInString(trimmed) {
+			level := headingLevel(&styles, lines[idx+1][:1])
+			output = append(output, "", strings.Repeat("#", level)+" "+inline(trimmed), "")
+			idx++
+			continue
+		}
+
+		if line == ".." || strings.HasPrefix(line, ".. ") {
+			block, next := indentedBlock(lines, idx+1)
+			if match := rstDirectivePattern.FindStringSubmatch(line); match != nil {
+				switch match[1] {
+				case "code-block", "code", "sourcecode":
+					// Options come before the code
+					for len(block) > 0 && (strings.HasPrefix(block[0], ":") || strings.TrimSpace(block[0]) == "") {
+						block = block[1:]
+					}
+					output = appendFenced(output, strings.TrimSpace(match[2]), block)
+				case "image", "figure":
+					output = append(output, "", "![]("+strings.TrimSpace(match[2])+")", "")
+				default:
+					output = append(output, "", "> **"+match[1]+"** "+inline(match[2]))
+					for _, quoted := range block {
+						output = append(output, "> "+inline(quoted))
+					}
+					output = append(output, "")
+				}
+			}
+			idx = next - 1
+			continue
+		}
+
+		// A paragraph ending in :: introduces a literal block, leaving one colon if it has text
+		if strings.HasSuffix(trimmed, "::") {
+			if block, next := indentedBlock(lines, idx+1); block != nil {
+				text := strings.TrimSuffix(strings.TrimRight(line, " "), "::")
+				if strings.TrimSpace(text) != "" {
+					if !strings.HasSuffix(text, " ") {
+						text += ":"
+					}
+					output = append(output, inline(strings.TrimRight(text, " ")))
+				}
+				output = appendFenced(output, "", block)
+				idx = next - 1
+				continue
+			}
+		}
+
+		output = append(output, inline(line))
+	}
+	return strings.Join(output, "\n")
+}
+
+var (
+	orgHeadingPattern  = regexp.MustCompile(`^(\*+) +(.*)$`)
+	orgKeywordPattern  = regexp.MustCompile(`^#\+(\w+):\s*(.*)$`)
+	orgBlockPattern    = regexp.MustCompile(`(?i)^\s*#\+begin_(\w+)\s*(\S*)`)
+	orgCodePattern     = regexp.MustCompile(`(^|[\s(])[=~](\S|\S.*?\S)[=~]($|[\s.,;:!?)])`)
+	orgLinkPattern     = regexp.MustCompile(`\[\[([^\]]+)\]\[([^\]]+)\]\]`)
+	orgBareLinkPattern = regexp.MustCompile(`\[\[([^\]]+)\]\]`)
+	orgBoldPattern     = regexp.MustCompile(`(^|[\s(])\*([^\s*]|[^\s*][^*]*[^\s*])\*($|[\s.,;:!?)])`)
+	orgItalicPattern   = regexp.MustCompile(`(^|[\s(])/([^\s/]|[^\s/][^/]*[^\s/])/($|[\s.,;:!?)])`)
+	orgStrikePattern   = regexp.MustCompile(`(^|[\s(])\+([^\s+]|[^\s+][^+]*[^\s+])\+($|[\s.,;:!?)])`)
+	orgRulePattern     = regexp.MustCompile(`^\s*\|[-+]+\|?\s*$`)
+)
+
+// Only the parts of Org which READMEs commonly use are translated: the title, headings,
+// blocks, links, tables and inline markup. Lists are already markdown and other keywords
+// and comments are dropped.
+func orgToMarkdown(source string) string {
+	inline := func(line string) string {
+		var literals []string
+		line = orgCodePattern.ReplaceAllStringFunc(line, func(code string) string {
+			match := orgCodePattern.FindStringSubmatch(code)
+			literals = append(literals, "`"+match[2]+"`")
+			return match[1] + "\x00" + match[3]
+		})
+		line = orgLinkPattern.ReplaceAllStringFunc(line, func(link string) string {
+			match := orgLinkPattern.FindStringSubmatch(link)
+			return "[" + match[2] + "](" + strings.TrimPrefix(match[1], "file:") + ")"
+		})
+		line = orgBareLinkPattern.ReplaceAllStringFunc(line, func(link string) string {
+			target := strings.TrimPrefix(orgBareLinkPattern.FindStringSubmatch(link)[1], "file:")
+			return "[" + target + "](" + target + ")"
+		})
+		line = orgBoldPattern.ReplaceAllString(line, "$1**$2**$3")
+		line = orgItalicPattern.ReplaceAllString(line, "$1*$2*$3")
+		line = orgStrikePattern.ReplaceAllString(line, "$1~~$2~~$3")
+		for _, literal := range literals {
+			line = strings.Replace(line, "\x00", literal, 1)
+		}
+		return line
+	}
+
+	lines := documentLines(source)
+	var output []string
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+
+		if match := orgBlockPattern.FindStringSubmatch(line); match != nil {
+			kind := strings.ToLower(match[1])
+			end := idx + 1
+			for end < len(lines) && !strings.EqualFold(strings.TrimSpace(lines[end]), "#+end_"+kind) {
+				end++
+			}
+			block := lines[idx+1 : end]
+			switch kind {
+			case "src":
+				output = appendFenced(output, match[2], block)
+			case "example":
+				output = appendFenced(output, "", block)
+			case "quote":
+				output = append(output, "")
+				for _, quoted := range block {
+					output = append(output, "> "+inline(strings.TrimSpace(quoted)))
+				}
+				output = append(output, "")
+			default:
+				for _, inner := range block {
+					output = append(output, inline(inner))
+				}
+			}
+			idx = end
+			continue
+		}
+
+		if match := orgKeywordPattern.FindStringSubmatch(line); match != nil {
+			if strings.EqualFold(match[1], "title") {
+				output = append(output, "", "# "+inline(match[2]), "")
+			}
+			continue
+		}
+		if line == "#" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		if match := orgHeadingPattern.FindStringSubmatch(line); match != nil {
+			level := min(len(match[1]), 6)
+			output = append(output, "", strings.Repeat("#", level)+" "+inline(match[2]), "")
+			continue
+		}
+		// Markdown tables only have a rule under their header, which is written with pipes
+		if orgRulePattern.MatchString(line) {
+			output = append(output, strings.ReplaceAll(line, "+", "|"))
+			continue
+		}
+		output = append(output, inline(line))
+	}
+	return strings.Join(output, "\n")
+}
@@ -0,0 +1,35 @@
+package views
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRstCodeAfterList(t *testing.T) {
+	sources := []string{
+		"- a\n- b\n\n.. code::\n\n   x := 1\n",
+		"- a\n- b\n\n.. code:: go\n\n   x := 1\n",
+		"1. a\n2. b\n\n::\n\n   x := 1\n",
+		"* a\n\n  more of a\n\n.. code-block:: sh\n\n   echo x\n",
+	}
+	for _, source := range sources {
+		rendered := string(mdBytesToHtml([]byte(rstToMarkdown(source)), nil))
+		list := strings.Index(rendered, "</ul>")
+		if list == -1 {
+			list = strings.Index(rendered, "</ol>")
+		}
+		code := strings.Index(rendered, "<pre>")
+		if list == -1 || code == -1 || code < list {
+			t.Errorf("rstToMarkdown(%q) doesn't render the code after the list:\n%s", source, rendered)
+		}
+	}
+}
+
+func TestOrgCodeAfterList(t *testing.T) {
+	source := "- a\n- b\n\n#+begin_src go\nx := 1\n#+end_src\n"
+	rendered := string(mdBytesToHtml([]byte(orgToMarkdown(source)), nil))
+	list, code := strings.Index(rendered, "</ul>"), strings.Index(rendered, "<pre>")
+	if list == -1 || code == -1 || code < list {
+		t.Errorf("orgToMarkdown(%q) doesn't render the code after the list:\n%s", source, rendered)
+	}
+}
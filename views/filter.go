@@ -0,0 +1,120 @@
+package views
+
+import (
+	"path"
+	"strings"
+)
+
+// Patterns are globs as understood by path.Match, so * doesn't match across a /, though
+// paths also take ** as a whole part matching any number of folders
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Without include patterns everything not excluded is allowed
+func (self Filter) Allows(name string) bool {
+	if len(self.Include) != 0 && !matchesAny(self.Include, name) {
+		return false
+	}
+	return !matchesAny(self.Exclude, name)
+}
+
+// The parts of the pattern and path are matched one at a time with ** trying every split
+func matchPathParts(patternParts []string, nameParts []string) bool {
+	for len(patternParts) != 0 {
+		if patternParts[0] == "**" {
+			for skip := 0; skip <= len(nameParts); skip++ {
+				if matchPathParts(patternParts[1:], nameParts[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(nameParts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patternParts[0], nameParts[0]); !ok {
+			return false
+		}
+		patternParts, nameParts = patternParts[1:], nameParts[1:]
+	}
+	return len(nameParts) == 0
+}
+
+func matchesAnyPath(patterns []string, name string) bool {
+	nameParts := strings.Split(name, "/")
+	for _, pattern := range patterns {
+		if matchPathParts(strings.Split(pattern, "/"), nameParts) {
+			return true
+		}
+	}
+	return false
+}
+
+// The name itself and each directory containing it, e.g., a/b/c gives a/b/c, a/b and a
+func pathAncestors(name string) []string {
+	ancestors := make([]string, 0)
+	for current := name; current != "." && current != "/" && current != ""; current = path.Dir(current) {
+		ancestors = append(ancestors, current)
+	}
+	return ancestors
+}
+
+// Whether the name matches the start of the pattern with parts left over, which a ** can
+// always have since it matches nothing too
+func matchesPathPrefix(patternParts []string, nameParts []string) bool {
+	for len(nameParts) != 0 {
+		if len(patternParts) == 0 {
+			return false
+		}
+		if patternParts[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(patternParts[0], nameParts[0]); !ok {
+			return false
+		}
+		patternParts, nameParts = patternParts[1:], nameParts[1:]
+	}
+	return len(patternParts) != 0
+}
+
+// Whether some path below the directory name could match one of the patterns
+func couldMatchBelow(patterns []string, name string) bool {
+	nameParts := strings.Split(name, "/")
+	for _, pattern := range patterns {
+		if matchesPathPrefix(strings.Split(pattern, "/"), nameParts) {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths are matched along with their parent directories so that excluding vendor
+// excludes everything below it, and directories leading to included paths are kept
+func (self Filter) AllowsPath(name string, isDir bool) bool {
+	ancestors := pathAncestors(name)
+	for _, ancestor := range ancestors {
+		if matchesAnyPath(self.Exclude, ancestor) {
+			return false
+		}
+	}
+	if len(self.Include) == 0 {
+		return true
+	}
+	for _, ancestor := range ancestors {
+		if matchesAnyPath(self.Include, ancestor) {
+			return true
+		}
+	}
+	return isDir && couldMatchBelow(self.Include, name)
+}
@@ -0,0 +1,36 @@
+package views
+
+import "testing"
+
+func TestAllowsPath(t *testing.T) {
+	cases := []struct {
+		filter Filter
+		name   string
+		isDir  bool
+		want   bool
+	}{
+		{Filter{Exclude: []string{"vendor"}}, "vendor/lib/a.go", false, false},
+		{Filter{Exclude: []string{"vendor"}}, "src/vendor/a.go", false, true},
+		{Filter{Exclude: []string{"*/testdata"}}, "pkg/testdata/a.txt", false, false},
+		{Filter{Exclude: []string{"*/testdata"}}, "pkg/sub/testdata/a.txt", false, true},
+		{Filter{Exclude: []string{"**/testdata"}}, "testdata/a.txt", false, false},
+		{Filter{Exclude: []string{"**/testdata"}}, "pkg/testdata/a.txt", false, false},
+		{Filter{Exclude: []string{"**/testdata"}}, "pkg/sub/testdata/a.txt", false, false},
+		{Filter{Exclude: []string{"**/testdata"}}, "pkg/sub/testdata2/a.txt", false, true},
+		{Filter{Exclude: []string{"pkg/**/*.gen.go"}}, "pkg/a/b/c.gen.go", false, false},
+		{Filter{Exclude: []string{"pkg/**/*.gen.go"}}, "cmd/a/b/c.gen.go", false, true},
+		{Filter{Include: []string{"docs/**/*.md"}}, "docs", true, true},
+		{Filter{Include: []string{"docs/**/*.md"}}, "docs/a/b", true, true},
+		{Filter{Include: []string{"docs/**/*.md"}}, "docs/a/b/c.md", false, true},
+		{Filter{Include: []string{"docs/**/*.md"}}, "docs/a/b/c.txt", false, false},
+		{Filter{Include: []string{"docs/**/*.md"}}, "src", true, false},
+		{Filter{Include: []string{"src/api"}}, "src", true, true},
+		{Filter{Include: []string{"src/api"}}, "src/api/v1/a.go", false, true},
+		{Filter{Include: []string{"src/api"}}, "src/web/a.go", false, false},
+	}
+	for _, c := range cases {
+		if got := c.filter.AllowsPath(c.name, c.isDir); got != c.want {
+			t.Errorf("%+v.AllowsPath(%q, %t) = %t, want %t", c.filter, c.name, c.isDir, got, c.want)
+		}
+	}
+}
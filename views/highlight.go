@@ -0,0 +1,252 @@
+package views
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Fenced code in a language we know is highlighted by wrapping its keywords, strings,
+// comments and numbers in spans of these classes, which the styles colour
+const (
+	codeKeywordClass = "codeKeyword"
+	codeStringClass  = "codeString"
+	codeCommentClass = "codeComment"
+	codeNumberClass  = "codeNumber"
+)
+
+var codeClassPattern = regexp.MustCompile("^(" + strings.Join([]string{codeKeywordClass, codeStringClass, codeCommentClass, codeNumberClass}, "|") + ")$")
+
+// Only what tells the tokens of a language apart is described, which is enough for the
+// colours to follow the code without parsing it
+type languageSyntax struct {
+	keywords      map[string]bool
+	lineComments  []string
+	blockComments [][2]string
+	quotes        string
+	// Quotes which may span lines are only closed by the same quote, e.g., Python's """
+	multilineQuotes []string
+}
+
+func keywords(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(list) {
+		set[word] = true
+	}
+	return set
+}
+
+var (
+	cKeywords = "auto break case char const continue default do double else enum extern float for goto if " +
+		"inline int long register restrict return short signed sizeof static struct switch typedef union " +
+		"unsigned void volatile while bool true false NULL"
+	javascriptKeywords = "async await break case catch class const continue debugger default delete do else " +
+		"export extends false finally for function if import in instanceof let new null return static super " +
+		"switch this throw true try typeof undefined var void while with yield"
+
+	goSyntax = &languageSyntax{
+		keywords: keywords("break case chan const continue default defer else fallthrough for func go goto if " +
+			"import interface map package range return select struct switch type var true false nil iota"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          "\"'",
+		multilineQuotes: []string{"`"},
+	}
+	cSyntax = &languageSyntax{
+		keywords:      keywords(cKeywords),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+	}
+	cppSyntax = &languageSyntax{
+		keywords: keywords(cKeywords + " catch class constexpr delete explicit friend namespace new nullptr " +
+			"operator private protected public template this throw try typename using virtual"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+	}
+	javaSyntax = &languageSyntax{
+		keywords: keywords("abstract boolean break byte case catch char class const continue default do double " +
+			"else enum extends final finally float for if implements import instanceof int interface long new " +
+			"package private protected public return short static super switch this throw throws try void " +
+			"volatile while true false null var record"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'",
+	}
+	javascriptSyntax = &languageSyntax{
+		keywords:        keywords(javascriptKeywords),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          "\"'",
+		multilineQuotes: []string{"`"},
+	}
+	typescriptSyntax = &languageSyntax{
+		keywords: keywords(javascriptKeywords + " any boolean declare enum implements interface keyof namespace " +
+			"never number private protected public readonly string type unknown"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          "\"'",
+		multilineQuotes: []string{"`"},
+	}
+	rustSyntax = &languageSyntax{
+		keywords: keywords("as async await break const continue crate dyn else enum extern false fn for if impl " +
+			"in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe " +
+			"use where while"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		// Single quotes are left alone since they also start lifetimes
+		quotes: "\"",
+	}
+	pythonSyntax = &languageSyntax{
+		keywords: keywords("and as assert async await break class continue def del elif else except False " +
+			"finally for from global if import in is lambda None nonlocal not or pass raise return True try " +
+			"while with yield"),
+		lineComments:    []string{"#"},
+		quotes:          "\"'",
+		multilineQuotes: []string{`"""`, `'''`},
+	}
+	shellSyntax = &languageSyntax{
+		keywords: keywords("case do done elif else esac export fi for function if in local return then until " +
+			"while"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	jsonSyntax = &languageSyntax{
+		keywords: keywords("true false null"),
+		quotes:   "\"",
+	}
+)
+
+// Languages are named as in the info string of the fence, ignoring case
+var languageSyntaxes = map[string]*languageSyntax{
+	"go":         goSyntax,
+	"golang":     goSyntax,
+	"c":          cSyntax,
+	"h":          cSyntax,
+	"cpp":        cppSyntax,
+	"c++":        cppSyntax,
+	"cc":         cppSyntax,
+	"hpp":        cppSyntax,
+	"java":       javaSyntax,
+	"javascript": javascriptSyntax,
+	"js":         javascriptSyntax,
+	"jsx":        javascriptSyntax,
+	"typescript": typescriptSyntax,
+	"ts":         typescriptSyntax,
+	"tsx":        typescriptSyntax,
+	"rust":       rustSyntax,
+	"rs":         rustSyntax,
+	"python":     pythonSyntax,
+	"py":         pythonSyntax,
+	"sh":         shellSyntax,
+	"bash":       shellSyntax,
+	"shell":      shellSyntax,
+	"zsh":        shellSyntax,
+	"json":       jsonSyntax,
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c >= 0x80
+}
+
+// The end of the comment starting at idx, or idx when none does. A # only starts a comment
+// at the start of a word so that, e.g., ${#list} in a shell script isn't one.
+func (syntax *languageSyntax) commentEnd(code string, idx int) int {
+	rest := code[idx:]
+	for _, marker := range syntax.lineComments {
+		if !strings.HasPrefix(rest, marker) {
+			continue
+		}
+		if marker == "#" && idx > 0 && !strings.ContainsRune(" \t\n", rune(code[idx-1])) {
+			continue
+		}
+		if end := strings.IndexByte(rest, '\n'); end != -1 {
+			return idx + end
+		}
+		return len(code)
+	}
+	for _, markers := range syntax.blockComments {
+		if !strings.HasPrefix(rest, markers[0]) {
+			continue
+		}
+		if end := strings.Index(rest[len(markers[0]):], markers[1]); end != -1 {
+			return idx + len(markers[0]) + end + len(markers[1])
+		}
+		return len(code)
+	}
+	return idx
+}
+
+// The end of the string starting at idx, or idx when none does. Backslashes escape the
+// next character and strings which can't span lines end with the line when left open.
+func (syntax *languageSyntax) stringEnd(code string, idx int) int {
+	rest := code[idx:]
+	for _, quote := range syntax.multilineQuotes {
+		if !strings.HasPrefix(rest, quote) {
+			continue
+		}
+		if end := strings.Index(rest[len(quote):], quote); end != -1 {
+			return idx + len(quote) + end + len(quote)
+		}
+		return len(code)
+	}
+	if strings.IndexByte(syntax.quotes, code[idx]) == -1 {
+		return idx
+	}
+	for end := idx + 1; end < len(code); end++ {
+		switch code[end] {
+		case '\\':
+			end++
+		case code[idx]:
+			return end + 1
+		case '\n':
+			return end
+		}
+	}
+	return len(code)
+}
+
+func highlightCode(code string, syntax *languageSyntax) string {
+	var sb strings.Builder
+	span := func(class string, text string) {
+		fmt.Fprintf(&sb, `<span class="%s">%s</span>`, class, html.EscapeString(text))
+	}
+
+	for idx := 0; idx < len(code); {
+		if end := syntax.commentEnd(code, idx); end != idx {
+			span(codeCommentClass, code[idx:end])
+			idx = end
+			continue
+		}
+		if end := syntax.stringEnd(code, idx); end != idx {
+			span(codeStringClass, code[idx:end])
+			idx = end
+			continue
+		}
+
+		end := idx + 1
+		switch c := code[idx]; {
+		case '0' <= c && c <= '9':
+			// Hex, floats, exponents and separators are all taken in with the digits
+			for end < len(code) && (isIdentifierByte(code[end]) || code[end] == '.') {
+				end++
+			}
+			span(codeNumberClass, code[idx:end])
+		case isIdentifierByte(c):
+			for end < len(code) && isIdentifierByte(code[end]) {
+				end++
+			}
+			if syntax.keywords[code[idx:end]] {
+				span(codeKeywordClass, code[idx:end])
+			} else {
+				sb.WriteString(html.EscapeString(code[idx:end]))
+			}
+		default:
+			sb.WriteString(html.EscapeString(code[idx:end]))
+		}
+		idx = end
+	}
+	return sb.String()
+}
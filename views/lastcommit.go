@@ -0,0 +1,158 @@
+package views
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type LastCommit struct {
+	Hash    plumbing.Hash
+	Subject string
+	Date    time.Time
+	Age     string
+}
+
+// The paths below prefix whose entries differ between the trees, either of which may
+// be nil, where subtrees with the same hash are skipped without being read
+func changedPaths(from *object.Tree, to *object.Tree, prefix string, changed map[string]bool) error {
+	entries := make(map[string][2]*object.TreeEntry)
+	if from != nil {
+		for idx := range from.Entries {
+			pair := entries[from.Entries[idx].Name]
+			pair[0] = &from.Entries[idx]
+			entries[from.Entries[idx].Name] = pair
+		}
+	}
+	if to != nil {
+		for idx := range to.Entries {
+			pair := entries[to.Entries[idx].Name]
+			pair[1] = &to.Entries[idx]
+			entries[to.Entries[idx].Name] = pair
+		}
+	}
+
+	for name, pair := range entries {
+		oldEntry, newEntry := pair[0], pair[1]
+		if oldEntry != nil && newEntry != nil && oldEntry.Hash == newEntry.Hash && oldEntry.Mode == newEntry.Mode {
+			continue
+		}
+		fullPath := path.Join(prefix, name)
+		changed[fullPath] = true
+
+		var oldTree, newTree *object.Tree = nil, nil
+		var err error
+		if oldEntry != nil && oldEntry.Mode == filemode.Dir {
+			oldTree, err = from.Tree(name)
+			if err != nil {
+				return err
+			}
+		}
+		if newEntry != nil && newEntry.Mode == filemode.Dir {
+			newTree, err = to.Tree(name)
+			if err != nil {
+				return err
+			}
+		}
+		if oldTree != nil || newTree != nil {
+			err = changedPaths(oldTree, newTree, fullPath, changed)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The paths a commit changed, where a merge only changes what differs from every
+// parent so that the change is credited to the commit on the merged branch
+func commitChangedPaths(commit *object.Commit) (map[string]bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if commit.NumParents() == 0 {
+		changed := make(map[string]bool)
+		return changed, changedPaths(nil, tree, "", changed)
+	}
+
+	var result map[string]bool = nil
+	err = commit.Parents().ForEach(func(parent *object.Commit) error {
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		changed := make(map[string]bool)
+		err = changedPaths(parentTree, tree, "", changed)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			result = changed
+			return nil
+		}
+		for changedPath := range result {
+			if !changed[changedPath] {
+				delete(result, changedPath)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// The newest commit changing each path in top's tree, found in a single walk of its
+// history which stops once every path is accounted for
+func (self *RepoIndex) lastCommits(top *object.Commit) (map[string]LastCommit, error) {
+	if cached, ok := self.lastCommitCache[top.Hash]; ok {
+		return cached, nil
+	}
+
+	tree, err := top.Tree()
+	if err != nil {
+		return nil, err
+	}
+	remaining := make(map[string]bool)
+	err = changedPaths(nil, tree, "", remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	found := make(map[string]LastCommit, len(remaining))
+	commitIter := object.NewCommitIterCTime(top, nil, nil)
+	defer commitIter.Close()
+	for len(remaining) != 0 {
+		commit, err := commitIter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		changed, err := commitChangedPaths(commit)
+		if err != nil {
+			return nil, err
+		}
+		for changedPath := range changed {
+			if !remaining[changedPath] {
+				continue
+			}
+			delete(remaining, changedPath)
+			found[changedPath] = LastCommit{
+				Hash:    commit.Hash,
+				Subject: strings.TrimSpace(strings.Split(commit.Message, "\n\n")[0]),
+				Date:    commit.Committer.When,
+				Age:     relativeAge(commit.Committer.When, now),
+			}
+		}
+	}
+
+	self.lastCommitCache[top.Hash] = found
+	return found, nil
+}
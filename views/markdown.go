@@ -0,0 +1,264 @@
+package views
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/microcosm-cc/bluemonday"
+	blackfriday "github.com/russross/blackfriday/v2"
+)
+
+// Relative links in markdown rendered from dir within a tree are rewritten to the pages of what
+// they point to, or to the raw file for images, where the page rendering the markdown is in the
+// directory treeBase relative to the tree's pages and the raw files are beside the tree's pages
+type markdownLinks struct {
+	context  *treeContext
+	dir      string
+	treeBase string
+}
+
+// Links which point to nothing in the tree keep their destination but are wrapped in a span
+// of this class so that they stand out
+const brokenLinkClass = "brokenLink"
+
+var brokenLinkPattern = regexp.MustCompile("^" + brokenLinkClass + "$")
+
+type markdownRenderer struct {
+	*blackfriday.HTMLRenderer
+	broken map[*blackfriday.Node]bool
+}
+
+func (renderer *markdownRenderer) RenderNode(writer io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	if node.Type == blackfriday.CodeBlock {
+		// The language is the first word of the info string as blackfriday takes it
+		language, _, _ := strings.Cut(strings.TrimSpace(strings.ReplaceAll(string(node.Info), "\t", " ")), " ")
+		if syntax, ok := languageSyntaxes[strings.ToLower(language)]; ok {
+			fmt.Fprintf(writer, "\n<pre><code class=\"language-%s\">%s</code></pre>\n", html.EscapeString(language), highlightCode(string(node.Literal), syntax))
+			return blackfriday.GoToNext
+		}
+	}
+	if renderer.broken[node] && entering {
+		io.WriteString(writer, `<span class="`+brokenLinkClass+`" title="Not found in this tree">`)
+	}
+	status := renderer.HTMLRenderer.RenderNode(writer, node, entering)
+	if renderer.broken[node] && !entering {
+		io.WriteString(writer, "</span>")
+	}
+	return status
+}
+
+// Links are left alone when links is nil, e.g., for markdown which isn't part of a tree,
+// and only markdown within a tree gets a table of contents
+func renderMarkdown(markdown []byte, links *markdownLinks) []byte {
+	renderer := &markdownRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{Flags: blackfriday.CommonHTMLFlags}),
+		broken:       make(map[*blackfriday.Node]bool),
+	}
+	extensions := blackfriday.CommonExtensions | blackfriday.AutoHeadingIDs
+	parser := blackfriday.New(blackfriday.WithRenderer(renderer), blackfriday.WithExtensions(extensions))
+	ast := parser.Parse(markdown)
+
+	var headings []tocHeading
+	ids := make(map[string]bool)
+	ast.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch node.Type {
+		case blackfriday.Heading:
+			if node.HeadingID != "" && !node.IsTitleblock {
+				node.HeadingID = uniqueHeadingID(node.HeadingID, ids)
+				headings = append(headings, tocHeading{node.Level, node.HeadingID, nodeText(node)})
+			}
+		case blackfriday.Item:
+			checkTaskItem(node)
+		}
+		return blackfriday.GoToNext
+	})
+
+	var buffer bytes.Buffer
+	renderer.RenderHeader(&buffer, ast)
+	if links != nil && links.context.tocHeadings != 0 && uint(len(headings)) >= links.context.tocHeadings {
+		writeTableOfContents(&buffer, headings)
+	}
+	ast.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if links != nil && entering && (node.Type == blackfriday.Link || node.Type == blackfriday.Image) {
+			destination, ok := links.rewrite(string(node.LinkData.Destination), node.Type == blackfriday.Image)
+			if ok {
+				node.LinkData.Destination = []byte(destination)
+			} else {
+				renderer.broken[node] = true
+			}
+		}
+		return renderer.RenderNode(&buffer, node, entering)
+	})
+	renderer.RenderFooter(&buffer, ast)
+	return buffer.Bytes()
+}
+
+// Only the classes we give the markdown are kept, along with the language of fenced code
+func sanitizeMarkdown(unsafe []byte) template.HTML {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(brokenLinkPattern).OnElements("span")
+	policy.AllowAttrs("class").Matching(codeClassPattern).OnElements("span")
+	policy.AllowAttrs("class").Matching(tableOfContentsPattern).OnElements("details")
+	policy.AllowAttrs("class").Matching(languagePattern).OnElements("code")
+	// Heading anchors may contain any letter, which the standard attributes don't allow
+	policy.AllowAttrs("id").Matching(headingIDPattern).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	policy.AllowAttrs("type").Matching(checkboxPattern).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").Matching(bluemonday.Paragraph).OnElements("input")
+	return template.HTML(policy.SanitizeBytes(unsafe))
+}
+
+var (
+	languagePattern  = regexp.MustCompile(`^language-[\w.+#-]+$`)
+	headingIDPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+	checkboxPattern  = regexp.MustCompile(`^checkbox$`)
+)
+
+// Headings with the same text get anchors numbered from the second, e.g., usage and usage-1
+func uniqueHeadingID(id string, ids map[string]bool) string {
+	unique := id
+	for count := 1; ids[unique]; count++ {
+		unique = fmt.Sprintf("%s-%d", id, count)
+	}
+	ids[unique] = true
+	return unique
+}
+
+// The text of a node without any of its markup
+func nodeText(node *blackfriday.Node) string {
+	var sb strings.Builder
+	node.Walk(func(child *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && (child.Type == blackfriday.Text || child.Type == blackfriday.Code) {
+			sb.Write(child.Literal)
+		}
+		return blackfriday.GoToNext
+	})
+	return sb.String()
+}
+
+// List items starting with [ ] or [x] are tasks, whose marker is replaced by a checkbox
+func checkTaskItem(item *blackfriday.Node) {
+	paragraph := item.FirstChild
+	if paragraph == nil || paragraph.Type != blackfriday.Paragraph {
+		return
+	}
+	text := paragraph.FirstChild
+	if text == nil || text.Type != blackfriday.Text || len(text.Literal) < len("[ ] ") {
+		return
+	}
+	var checkbox string
+	switch strings.ToLower(string(text.Literal[:len("[ ] ")])) {
+	case "[ ] ":
+		checkbox = `<input type="checkbox" disabled>`
+	case "[x] ":
+		checkbox = `<input type="checkbox" checked disabled>`
+	default:
+		return
+	}
+	text.Literal = text.Literal[len("[ ] "):]
+	marker := blackfriday.NewNode(blackfriday.HTMLSpan)
+	marker.Literal = []byte(checkbox + " ")
+	text.InsertBefore(marker)
+}
+
+type tocHeading struct {
+	level int
+	id    string
+	text  string
+}
+
+const tableOfContentsClass = "tableOfContents"
+
+var tableOfContentsPattern = regexp.MustCompile("^" + tableOfContentsClass + "$")
+
+// Headings are nested under the closest heading before them of a higher level
+func writeTableOfContents(writer io.Writer, headings []tocHeading) {
+	fmt.Fprintf(writer, "<details class=\"%s\">\n<summary>Contents</summary>\n", tableOfContentsClass)
+	var levels []int
+	for _, heading := range headings {
+		for len(levels) > 0 && heading.level < levels[len(levels)-1] {
+			levels = levels[:len(levels)-1]
+			io.WriteString(writer, "</li>\n</ul>\n")
+		}
+		if len(levels) > 0 && heading.level == levels[len(levels)-1] {
+			io.WriteString(writer, "</li>\n")
+		} else {
+			levels = append(levels, heading.level)
+			io.WriteString(writer, "<ul>\n")
+		}
+		fmt.Fprintf(writer, `<li><a href="#%s">%s</a>`, html.EscapeString(heading.id), html.EscapeString(heading.text))
+	}
+	for range levels {
+		io.WriteString(writer, "</li>\n</ul>\n")
+	}
+	io.WriteString(writer, "</details>\n")
+}
+
+// Links with a scheme or host, or only a fragment, are kept as they are. Links starting
+// with a slash are relative to the top of the tree, as they are on most forges.
+func (links *markdownLinks) rewrite(destination string, isImage bool) (string, bool) {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return destination, false
+	}
+	if parsed.Scheme != "" || parsed.Host != "" || parsed.Path == "" {
+		return destination, true
+	}
+
+	var target string
+	if strings.HasPrefix(parsed.Path, "/") {
+		target = path.Clean(strings.TrimPrefix(parsed.Path, "/"))
+	} else {
+		target = path.Join(links.dir, parsed.Path)
+	}
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return destination, false
+	}
+
+	var href string
+	if target == "." {
+		href = path.Join(links.treeBase, "..", "index.html")
+	} else {
+		entry, err := links.context.root.FindEntry(target)
+		if err != nil || !links.context.pathFilter.AllowsPath(target, entry.Mode == filemode.Dir) {
+			return destination, false
+		}
+		switch {
+		case entry.Mode == filemode.Submodule:
+			// There's nothing of the submodule's to link to within this tree
+			return destination, true
+		case isImage && entry.Mode == filemode.Symlink:
+			file, err := links.context.root.File(target)
+			if err != nil {
+				return destination, false
+			}
+			linkTarget, err := file.Contents()
+			if err != nil {
+				return destination, false
+			}
+			symlink := resolveSymlink(links.context.root, target, linkTarget)
+			if symlink.Resolved == "" || symlink.isDir || !links.context.pathFilter.AllowsPath(symlink.Resolved, false) {
+				return destination, false
+			}
+			href = path.Join(links.treeBase, "..", rawDir, escapeTreePath(symlink.Resolved))
+		case isImage && entry.Mode != filemode.Dir:
+			href = path.Join(links.treeBase, "..", rawDir, escapeTreePath(target))
+		default:
+			href = links.treeBase + treePage(target)
+		}
+	}
+	if parsed.Fragment != "" {
+		href += "#" + parsed.EscapedFragment()
+	}
+	return href, true
+}
@@ -0,0 +1,83 @@
+package views
+
+import (
+	"html/template"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Notes are rendered with the root so that they link to the notes commits from any page
+type NotesView struct {
+	Notes []NoteData
+	Root  string
+}
+
+func newNotesView(notes []NoteData, root string) NotesView {
+	return NotesView{notes, root}
+}
+
+// This global is treated as a constant and should only be read
+var noteFuncMap = template.FuncMap{
+	"NotesView": newNotesView,
+}
+
+// Large notes trees are split into fanout directories, e.g., ab/cdef..., so the object
+// annotated is the whole path with the slashes removed
+func noteTarget(name string) (string, bool) {
+	hash := strings.ToLower(strings.ReplaceAll(name, "/", ""))
+	if len(hash) != 40 || !plumbing.IsHash(hash) {
+		return "", false
+	}
+	return hash, true
+}
+
+// The notes under every notes ref keyed by the hex of the object they annotate, which
+// may be a commit, tag, tree or blob
+func collectNotes(repository *git.Repository) (NoteMap, error) {
+	// This iterator is over the notes "branches" e.g., refs/notes/commits
+	noteIter, err := repository.Notes()
+	if err != nil {
+		return nil, err
+	}
+	defer noteIter.Close()
+
+	var noteMap NoteMap = make(NoteMap)
+	err = noteIter.ForEach(func(note *plumbing.Reference) error {
+		commit, err := repository.CommitObject(note.Hash())
+		if err != nil { // plumbing.ErrObjectNotFound is included here
+			return err
+		}
+
+		// Notes consist of a blob
+		fileIter, err := commit.Files()
+		if err != nil {
+			return err
+		}
+		return fileIter.ForEach(func(file *object.File) error {
+			target, ok := noteTarget(file.Name)
+			if !ok {
+				return nil
+			}
+			var blobData BlobData
+			err := blobData.fromFile(file, nil)
+			if err != nil {
+				return err
+			}
+			noteMap[target] = append(noteMap[target], NoteData{
+				Reference: string(note.Name()),
+				Namespace: strings.TrimPrefix(string(note.Name()), "refs/notes/"),
+				Hash:      commit.Hash,
+				Time:      commit.Committer.When,
+				Blob:      blobData,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return noteMap, nil
+}
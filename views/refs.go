@@ -3,8 +3,11 @@ package views
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"html/template"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,17 +28,117 @@ const (
 )
 
 type TagData struct {
-	Name   string
-	Target plumbing.Hash
-	Head   string
-	Tagger string
-	Date   time.Time
+	Name string
+	// The object the tag ref points to, which is the tag object for annotated tags
+	Hash      plumbing.Hash
+	Target    plumbing.Hash
+	Head      string
+	Message   string
+	Tagger    string
+	Email     string
+	Date      time.Time
+	Signature SignatureData
+	// The object at the end of the tag chain, which is only the same as target for commits
+	Object     plumbing.Hash
+	ObjectType plumbing.ObjectType
 }
 type TagDataSlice []TagData
 
-func (a TagDataSlice) Len() int           { return len(a) }
-func (a TagDataSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a TagDataSlice) Less(i, j int) bool { return a[i].Date.Before(a[j].Date) }
+func (a TagDataSlice) Len() int      { return len(a) }
+func (a TagDataSlice) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// Tags made at the same time, as by a script tagging several releases, are ordered by
+// their names with numbers compared by value so that v1.10 comes after v1.9
+func (a TagDataSlice) Less(i, j int) bool {
+	if !a[i].Date.Equal(a[j].Date) {
+		return a[i].Date.Before(a[j].Date)
+	}
+	return versionLess(a[i].Name, a[j].Name)
+}
+
+func versionLess(a string, b string) bool {
+	for a != "" && b != "" {
+		aDigits := len(a) - len(strings.TrimLeft(a, "0123456789"))
+		bDigits := len(b) - len(strings.TrimLeft(b, "0123456789"))
+		if aDigits != 0 && bDigits != 0 {
+			aNumber, bNumber := strings.TrimLeft(a[:aDigits], "0"), strings.TrimLeft(b[:bDigits], "0")
+			if len(aNumber) != len(bNumber) {
+				return len(aNumber) < len(bNumber)
+			}
+			if aNumber != bNumber {
+				return aNumber < bNumber
+			}
+			a, b = a[aDigits:], b[bDigits:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// Tags are sorted newest first so the previous release is the next one pointing at a commit
+func (a TagDataSlice) previous(idx int) *TagData {
+	for older := idx + 1; older < len(a); older++ {
+		if !a[older].Target.IsZero() {
+			return &a[older]
+		}
+	}
+	return nil
+}
+
+type BranchData struct {
+	Name string
+	// Where the branch's pages are relative to the output root
+	Dir       string
+	Hash      plumbing.Hash
+	Head      string
+	Author    string
+	Date      time.Time
+	Age       string
+	IsDefault bool
+	// Relative to the default branch and only set when there is one
+	Ahead  int
+	Behind int
+	Stale  bool
+	// The compare page against the default branch when one is generated
+	Compare string
+}
+
+// What the branches partial renders, which leaves out the ahead and behind columns when
+// there's no default branch for them to count against
+type BranchTable struct {
+	Branches   []BranchData
+	HasDefault bool
+}
+
+func newBranchTable(branches []BranchData, hasDefault bool) BranchTable {
+	return BranchTable{branches, hasDefault}
+}
+
+// Branches without commits for this long are marked as stale
+const staleAge = 90 * 24 * time.Hour
+
+// Commits grouped by author as with git shortlog
+type ShortlogEntry struct {
+	Author  string
+	Commits []LogCommit
+}
+
+type TagPageData struct {
+	Tag      TagData
+	Message  template.HTML
+	Previous string
+	// The compare page against the previous tag when one is generated
+	Compare  string
+	Archives []string
+	Shortlog []ShortlogEntry
+	Notes    []NoteData
+	// The page of a tagged tree or blob, which are written under the tag's folder
+	ObjectPage string
+}
 
 type ShortRef struct {
 	Name string
@@ -70,68 +173,311 @@ func (self *ShortRef) fromRef(ref *plumbing.Reference) {
 	}
 }
 
-func (data *TagData) fromTag(tag *object.Tag) error {
-	commit, err := tag.Commit()
-	if err == nil {
-		data.Target = commit.Hash
-	} else if !(errors.Is(err, object.ErrUnsupportedObject)) {
+// Follows tags of tags until reaching the commit, tree or blob at the end of the chain
+func peelTag(tag *object.Tag, repo *git.Repository) (plumbing.Hash, plumbing.ObjectType, error) {
+	seen := make(map[plumbing.Hash]bool)
+	for tag.TargetType == plumbing.TagObject {
+		if seen[tag.Hash] {
+			return plumbing.ZeroHash, plumbing.InvalidObject, errors.New("tag " + tag.Name + " is part of a cycle")
+		}
+		seen[tag.Hash] = true
+		next, err := repo.TagObject(tag.Target)
+		if err != nil {
+			return plumbing.ZeroHash, plumbing.InvalidObject, err
+		}
+		tag = next
+	}
+	return tag.Target, tag.TargetType, nil
+}
+
+func (data *TagData) fromTag(tag *object.Tag, repo *git.Repository, verifier *Verifier) error {
+	peeled, peeledType, err := peelTag(tag, repo)
+	if err != nil {
 		return err
 	}
+	if peeledType == plumbing.CommitObject {
+		data.Target = peeled
+	}
 	data.Name = tag.Name
+	data.Hash = tag.Hash
 	data.Head = strings.Split(tag.Message, "\n\n")[0]
+	data.Message = tag.Message
 	data.Tagger = tag.Tagger.Name
+	data.Email = tag.Tagger.Email
 	data.Date = tag.Tagger.When
-	return nil
+	data.Object = peeled
+	data.ObjectType = peeledType
+	data.Signature, err = verifier.verifyTag(tag)
+	return err
 }
 
+// Lightweight tags to trees and blobs have no tagger or date to show
 func (data *TagData) fromReference(ref *plumbing.Reference, repo *git.Repository) error {
-	commit, err := repo.CommitObject(ref.Hash())
-	if err == nil {
-		data.Target = commit.Hash
-	} else if !(errors.Is(err, object.ErrUnsupportedObject)) {
+	obj, err := repo.Object(plumbing.AnyObject, ref.Hash())
+	if err != nil {
 		return err
 	}
 	data.Name = ref.Name().Short()
+	data.Hash = ref.Hash()
 	data.Head = ""
-	data.Tagger = commit.Author.Name
-	data.Date = commit.Committer.When
+	data.Object = ref.Hash()
+	data.ObjectType = obj.Type()
+	if commit, ok := obj.(*object.Commit); ok {
+		data.Target = commit.Hash
+		data.Tagger = commit.Author.Name
+		data.Email = commit.Author.Email
+		data.Date = commit.Committer.When
+	}
 	return nil
 }
 
-func (data *TagData) fromRefSwitch(tag *plumbing.Reference, repo *git.Repository) error {
+func (data *TagData) fromRefSwitch(tag *plumbing.Reference, repo *git.Repository, verifier *Verifier) error {
 	obj, err := repo.TagObject(tag.Hash())
 	switch err {
 	case nil: // This is an annotated tag
-		err = data.fromTag(obj)
+		err = data.fromTag(obj, repo, verifier)
 	case plumbing.ErrObjectNotFound:
 		err = data.fromReference(tag, repo)
 	}
 	return err
 }
 
-func generateRefs(branches *[]string, tags *TagDataSlice, data BaseData, buffer *bytes.Buffer) error {
+// The tags sorted from newest to oldest
+func collectTags(repository *git.Repository, verifier *Verifier) (TagDataSlice, error) {
+	tagIter, err := repository.Tags()
+	if err != nil {
+		return nil, err
+	}
+	defer tagIter.Close()
+
+	tags := make(TagDataSlice, 0)
+	err = tagIter.ForEach(func(tag *plumbing.Reference) error {
+		var data TagData
+		err = data.fromRefSwitch(tag, repository, verifier)
+		tags = append(tags, data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(sort.Reverse(tags))
+	return tags, nil
+}
+
+const tagDir = "tags"
+
+// Tag names may hold anything a ref can, e.g., '#' and '?', so the page of a tag relative
+// to the output root is named like the pages of a tree
+func tagPage(name string) string {
+	return path.Join(tagDir, escapeTreePath(name)) + ".html"
+}
+
+// Local branches are named by their last component while other refs keep their path
+// without the refs/ prefix so that, e.g., origin/main doesn't collide with main
+func refDir(name plumbing.ReferenceName) string {
+	if name.IsBranch() {
+		return filepath.Base(string(name))
+	}
+	return strings.TrimPrefix(string(name), "refs/")
+}
+
+// The remote-tracking branches and namespaced refs which are rendered like branches
+func CollectNamespaceRefs(repository *git.Repository, config Config) ([]*plumbing.Reference, error) {
+	refIter, err := repository.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refIter.Close()
+
+	refs := make([]*plumbing.Reference, 0)
+	err = refIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		name := ref.Name()
+		selected := config.Remotes && name.IsRemote()
+		for _, namespace := range config.Namespaces {
+			selected = selected || strings.HasPrefix(string(name), strings.TrimSuffix(namespace, "/")+"/")
+		}
+		if !selected || !config.RefFilter.Allows(string(name)) {
+			return nil
+		}
+		// Namespaces such as refs/pull may hold refs to objects other than commits
+		_, err := repository.CommitObject(ref.Hash())
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name() < refs[j].Name()
+	})
+	return refs, nil
+}
+
+// A coarse age like "3 days ago" as the exact date is on the commit page
+func relativeAge(when time.Time, now time.Time) string {
+	age := now.Sub(when)
+	units := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, unit := range units {
+		if count := int(age / unit.duration); count >= 1 {
+			if count == 1 {
+				return fmt.Sprintf("1 %s ago", unit.name)
+			}
+			return fmt.Sprintf("%d %ss ago", count, unit.name)
+		}
+	}
+	return "just now"
+}
+
+// The branches sorted from most to least recently committed to
+func collectBranches(refs []*plumbing.Reference, repository *git.Repository, index *RepoIndex, config Config) ([]BranchData, error) {
+	now := time.Now()
+	branches := make([]BranchData, 0, len(refs))
+	for _, branch := range refs {
+		commit, err := repository.CommitObject(branch.Hash())
+		if err != nil {
+			return nil, err
+		}
+		data := BranchData{
+			Name:   branch.Name().Short(),
+			Dir:    filepath.ToSlash(refDir(branch.Name())),
+			Hash:   commit.Hash,
+			Head:   strings.TrimSpace(strings.Split(commit.Message, "\n\n")[0]),
+			Author: commit.Author.Name,
+			Date:   commit.Committer.When,
+			Age:    relativeAge(commit.Committer.When, now),
+			Stale:  now.Sub(commit.Committer.When) > staleAge,
+		}
+		if index.defaultBranch != nil {
+			data.IsDefault = branch.Name() == index.defaultBranch.Name()
+			data.Ahead, data.Behind = index.aheadBehind(index.defaultBranch.Hash(), branch.Hash())
+			if config.CompareDefault && !data.IsDefault && branch.Name().IsBranch() {
+				pair := ComparePair{Base: index.defaultBranch.Name().Short(), Head: data.Name}
+				data.Compare = filepath.ToSlash(comparePath(pair))
+			}
+		}
+		branches = append(branches, data)
+	}
+
+	sort.SliceStable(branches, func(i, j int) bool {
+		return branches[i].Date.After(branches[j].Date)
+	})
+	return branches, nil
+}
+
+func generateRefs(branches *[]BranchData, namespaceRefs *[]BranchData, tags *TagDataSlice, hasDefault bool, data BaseData, buffer *bytes.Buffer) error {
 	partialsPath := filepath.Join("templates", "partials")
 	basePath := filepath.Join("templates", "base.html")
 	navPath := filepath.Join(partialsPath, "nav.html")
 	refsPath := filepath.Join(partialsPath, "content", "refs.html")
+	signaturePath := filepath.Join(partialsPath, "signature.html")
 	footPath := filepath.Join(partialsPath, "footer.html")
 	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
 	if err != nil {
 		return err
 	}
-	refsTempl, err := baseTempl.ParseFS(templates, refsPath)
+	refsTempl, err := template.Must(baseTempl.Funcs(refFuncMap).Funcs(signatureFuncMap).ParseFS(templates, refsPath)).ParseFS(templates, signaturePath)
 	if err != nil {
 		return err
 	}
 
 	err = refsTempl.Execute(buffer, struct {
-		Branches []string
-		Tags     TagDataSlice
+		Branches      []BranchData
+		NamespaceRefs []BranchData
+		Tags          TagDataSlice
+		HasDefault    bool
 		BaseData
 	}{
 		*branches,
+		*namespaceRefs,
 		*tags,
+		hasDefault,
+		data,
+	})
+	return err
+}
+
+// The commits reachable from top but not from since, which may be the zero hash. The
+// commits since reaches are found in the index rather than by reading each of them.
+func shortlogBetween(repository *git.Repository, index *RepoIndex, top plumbing.Hash, since plumbing.Hash) ([]ShortlogEntry, error) {
+	seen := make(map[plumbing.Hash]bool)
+	if !since.IsZero() {
+		seen = index.ancestors(since)
+	}
+
+	topCommit, err := repository.CommitObject(top)
+	if err != nil {
+		return nil, err
+	}
+	authorIndex := make(map[string]int)
+	shortlog := make([]ShortlogEntry, 0)
+	err = object.NewCommitIterCTime(topCommit, seen, nil).ForEach(func(commit *object.Commit) error {
+		author := commit.Author.Name
+		idx, ok := authorIndex[author]
+		if !ok {
+			idx = len(shortlog)
+			authorIndex[author] = idx
+			shortlog = append(shortlog, ShortlogEntry{Author: author})
+		}
+		shortlog[idx].Commits = append(shortlog[idx].Commits, LogCommit{
+			Hash:    commit.Hash,
+			Author:  author,
+			Date:    commit.Author.When,
+			Message: strings.TrimSpace(strings.Split(commit.Message, "\n\n")[0]),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(shortlog, func(i, j int) bool {
+		return len(shortlog[i].Commits) > len(shortlog[j].Commits)
+	})
+	return shortlog, nil
+}
+
+func generateTag(data TagPageData, base BaseData, buffer *bytes.Buffer) error {
+	partialsPath := filepath.Join("templates", "partials")
+	basePath := filepath.Join("templates", "base.html")
+	navPath := filepath.Join(partialsPath, "nav.html")
+	tagPath := filepath.Join(partialsPath, "content", "tag.html")
+	signaturePath := filepath.Join(partialsPath, "signature.html")
+	notesPath := filepath.Join(partialsPath, "notes.html")
+	blobPath := filepath.Join(partialsPath, "blob.html") // Notes are blobs
+	footPath := filepath.Join(partialsPath, "footer.html")
+	baseTempl, err := template.Must(template.Must(template.ParseFS(templates, basePath)).ParseFS(templates, navPath)).ParseFS(templates, footPath)
+	if err != nil {
+		return err
+	}
+	tagTempl, err := template.Must(baseTempl.Funcs(signatureFuncMap).Funcs(noteFuncMap).ParseFS(templates, tagPath)).ParseFS(templates, signaturePath, notesPath, blobPath)
+	if err != nil {
+		return err
+	}
+
+	err = tagTempl.Execute(buffer, struct {
+		TagPageData
+		BaseData
+	}{
 		data,
+		base,
 	})
 	return err
 }
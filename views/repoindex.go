@@ -0,0 +1,443 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// RepoIndex holds what is computed once per run and shared by every page
+type RepoIndex struct {
+	// The branch the landing page and the default comparisons are for, which may be nil
+	defaultBranch *plumbing.Reference
+	// Every commit reachable from the default branch, which most refs are measured against
+	defaultAncestors map[plumbing.Hash]bool
+	// Sorted hex representations of every commit in the repository
+	commits []string
+	parents map[plumbing.Hash][]plumbing.Hash
+	// The references pointing to each commit with tags peeled
+	refs map[plumbing.Hash][]ShortRef
+	// The commits branches and tags point to, each of which has a bit in a tipSet
+	tips    []plumbing.Hash
+	tipBits map[plumbing.Hash]int
+	// The tips each commit is reachable from
+	containing map[plumbing.Hash]tipSet
+	// Commits which have the key as their first parent from the oldest
+	children map[plumbing.Hash][]plumbing.Hash
+	// The newest commit time among a commit's children, or the start of the run when the
+	// branches and tags containing it changed, past which its page has to be rewritten
+	refreshTime map[plumbing.Hash]time.Time
+	// The commits each branch and tag points to keyed by full name with tags peeled
+	tipRefs map[string]plumbing.Hash
+	// The notes on each object keyed by its hex
+	notes NoteMap
+	// The origin's URL, which relative submodule URLs are resolved against
+	remoteURL string
+	// The last commit for each path keyed by the commit whose tree was listed
+	lastCommitCache map[plumbing.Hash]map[string]LastCommit
+	// The keys are read once rather than for every page showing a signature
+	verifier *Verifier
+}
+
+func NewRepoIndex(repository *git.Repository, baseDir string, config Config) (*RepoIndex, error) {
+	start := time.Now()
+	index := RepoIndex{
+		parents:     make(map[plumbing.Hash][]plumbing.Hash),
+		refs:        make(map[plumbing.Hash][]ShortRef),
+		tipBits:     make(map[plumbing.Hash]int),
+		containing:  make(map[plumbing.Hash]tipSet),
+		children:    make(map[plumbing.Hash][]plumbing.Hash),
+		refreshTime: make(map[plumbing.Hash]time.Time),
+		tipRefs:     make(map[string]plumbing.Hash),
+
+		lastCommitCache: make(map[plumbing.Hash]map[string]LastCommit),
+	}
+
+	parents := index.parents
+	times := make(map[plumbing.Hash]time.Time)
+	commitIter, err := repository.CommitObjects()
+	if err != nil {
+		return nil, err
+	}
+	defer commitIter.Close()
+	err = commitIter.ForEach(func(commit *object.Commit) error {
+		index.commits = append(index.commits, commit.Hash.String())
+		parents[commit.Hash] = commit.ParentHashes
+		times[commit.Hash] = commit.Committer.When
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(index.commits)
+
+	for hash, commitParents := range parents {
+		if len(commitParents) == 0 {
+			continue
+		}
+		parent := commitParents[0]
+		index.children[parent] = append(index.children[parent], hash)
+		index.refresh(parent, times[hash])
+	}
+	for _, children := range index.children {
+		sort.Slice(children, func(i, j int) bool {
+			if !times[children[i]].Equal(times[children[j]]) {
+				return times[children[i]].Before(times[children[j]])
+			}
+			return children[i].String() < children[j].String()
+		})
+	}
+
+	err = index.scanRefs(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	index.defaultBranch, err = findDefaultBranch(repository)
+	if err != nil {
+		return nil, err
+	}
+	if index.defaultBranch != nil {
+		index.defaultAncestors = index.ancestors(index.defaultBranch.Hash())
+	}
+
+	index.notes, err = collectNotes(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	index.remoteURL, err = findRemoteURL(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	index.verifier, err = newVerifier(config)
+	if err != nil {
+		return nil, err
+	}
+
+	index.propagateTips(times)
+
+	previous, err := readTipRefs(filepath.Join(baseDir, tipRefsFile))
+	if err != nil {
+		return nil, err
+	}
+	index.refreshMovedTips(previous, start)
+
+	return &index, nil
+}
+
+// A bit for each of RepoIndex.tips
+type tipSet []uint64
+
+func (set tipSet) has(bit int) bool {
+	return bit/64 < len(set) && set[bit/64]&(1<<(bit%64)) != 0
+}
+
+func (set tipSet) with(bit int) tipSet {
+	for len(set) <= bit/64 {
+		set = append(set, 0)
+	}
+	set[bit/64] |= 1 << (bit % 64)
+	return set
+}
+
+func (set tipSet) union(other tipSet) tipSet {
+	for len(set) < len(other) {
+		set = append(set, 0)
+	}
+	for idx, word := range other {
+		set[idx] |= word
+	}
+	return set
+}
+
+// The tips are handed from children to parents in a single pass over the commits, which
+// visits every commit after all of its children, rather than walking each tip's history
+func (self *RepoIndex) propagateTips(times map[plumbing.Hash]time.Time) {
+	for hash, refs := range self.refs {
+		if _, ok := times[hash]; !ok {
+			// Tags may point to trees and blobs which contain nothing
+			continue
+		}
+		for _, ref := range refs {
+			if ref.Type == BRANCH_E || ref.Type == TAG_E {
+				self.tipBits[hash] = len(self.tips)
+				self.tips = append(self.tips, hash)
+				self.containing[hash] = self.containing[hash].with(self.tipBits[hash])
+				break
+			}
+		}
+	}
+
+	remaining := make(map[plumbing.Hash]int)
+	for _, commitParents := range self.parents {
+		for _, parent := range commitParents {
+			remaining[parent]++
+		}
+	}
+	queue := make([]plumbing.Hash, 0)
+	for hash := range self.parents {
+		if remaining[hash] == 0 {
+			queue = append(queue, hash)
+		}
+	}
+	for len(queue) != 0 {
+		current := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for _, parent := range self.parents[current] {
+			if _, ok := self.parents[parent]; !ok {
+				// The parent is missing from shallow clones
+				continue
+			}
+			if tips := self.containing[current]; tips != nil {
+				self.containing[parent] = self.containing[parent].union(tips)
+			}
+			remaining[parent]--
+			if remaining[parent] == 0 {
+				queue = append(queue, parent)
+			}
+		}
+	}
+}
+
+// The branches and tags of the last run are kept with the pages, since neither a commit's
+// time nor a tag's says whether the commit's "Contained in" list changed
+const tipRefsFile = ".tips"
+
+// Each line is a hash and a full ref name as in packed-refs, and a missing file gives no refs
+func readTipRefs(path string) (map[string]plumbing.Hash, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tipRefs := make(map[string]plumbing.Hash)
+	for _, line := range strings.Split(string(contents), "\n") {
+		hash, name, found := strings.Cut(line, " ")
+		if found {
+			tipRefs[name] = plumbing.NewHash(hash)
+		}
+	}
+	return tipRefs, nil
+}
+
+// Written once every page is so that a failed run refreshes the same commits again
+func (self *RepoIndex) WriteTipRefs(baseDir string) error {
+	names := make([]string, 0, len(self.tipRefs))
+	for name := range self.tipRefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s %s\n", self.tipRefs[name], name)
+	}
+	return os.WriteFile(filepath.Join(baseDir, tipRefsFile), []byte(sb.String()), 0644)
+}
+
+// The commits reachable from exactly one of a moved ref's old and new commits are the ones
+// whose list gained or lost it, which is only the new commits when a branch moves forward.
+// Every commit is refreshed without the last run's refs as pages may predate the file.
+func (self *RepoIndex) refreshMovedTips(previous map[string]plumbing.Hash, when time.Time) {
+	if previous == nil {
+		for hash := range self.parents {
+			self.refresh(hash, when)
+		}
+		return
+	}
+	moved := func(name string) {
+		old, current := previous[name], self.tipRefs[name]
+		if old == current {
+			return
+		}
+		oldAncestors := make(map[plumbing.Hash]bool)
+		if !old.IsZero() {
+			oldAncestors = self.ancestors(old)
+		}
+		currentAncestors := make(map[plumbing.Hash]bool)
+		if !current.IsZero() {
+			currentAncestors = self.ancestors(current)
+		}
+		for hash := range oldAncestors {
+			if !currentAncestors[hash] {
+				self.refresh(hash, when)
+			}
+		}
+		for hash := range currentAncestors {
+			if !oldAncestors[hash] {
+				self.refresh(hash, when)
+			}
+		}
+	}
+	for name := range self.tipRefs {
+		moved(name)
+	}
+	for name := range previous {
+		if _, ok := self.tipRefs[name]; !ok {
+			moved(name)
+		}
+	}
+}
+
+// The branches and tags the commit is reachable from
+func (self *RepoIndex) containingRefs(hash plumbing.Hash) []ShortRef {
+	refs := make([]ShortRef, 0)
+	tips := self.containing[hash]
+	for bit, tip := range self.tips {
+		if !tips.has(bit) {
+			continue
+		}
+		for _, ref := range self.refs[tip] {
+			if ref.Type == BRANCH_E || ref.Type == TAG_E {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Type != refs[j].Type {
+			return refs[i].Type < refs[j].Type
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+// The child along the first-parent chain, which is the oldest one on the default branch
+// when there is one and the oldest otherwise
+func (self *RepoIndex) next(hash plumbing.Hash) plumbing.Hash {
+	children := self.children[hash]
+	if len(children) == 0 {
+		return plumbing.ZeroHash
+	}
+	if self.defaultBranch != nil {
+		if bit, ok := self.tipBits[self.defaultBranch.Hash()]; ok {
+			for _, child := range children {
+				if self.containing[child].has(bit) {
+					return child
+				}
+			}
+		}
+	}
+	return children[0]
+}
+
+// Every commit reachable from hash including itself
+func (self *RepoIndex) ancestors(hash plumbing.Hash) map[plumbing.Hash]bool {
+	visited := make(map[plumbing.Hash]bool)
+	queue := []plumbing.Hash{hash}
+	for len(queue) != 0 {
+		current := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+		queue = append(queue, self.parents[current]...)
+	}
+	return visited
+}
+
+// The number of commits reachable from head but not base and vice versa, which is read from
+// the tips containing each commit when both are tips and found by walking both otherwise,
+// other than the default branch which is walked once for every ref compared to it
+func (self *RepoIndex) aheadBehind(base plumbing.Hash, head plumbing.Hash) (int, int) {
+	baseBit, isBaseTip := self.tipBits[base]
+	headBit, isHeadTip := self.tipBits[head]
+	if isBaseTip && isHeadTip {
+		ahead, behind := 0, 0
+		for _, tips := range self.containing {
+			inBase, inHead := tips.has(baseBit), tips.has(headBit)
+			if inHead && !inBase {
+				ahead++
+			} else if inBase && !inHead {
+				behind++
+			}
+		}
+		return ahead, behind
+	}
+
+	baseAncestors := self.defaultAncestors
+	if self.defaultBranch == nil || base != self.defaultBranch.Hash() {
+		baseAncestors = self.ancestors(base)
+	}
+	headAncestors := self.ancestors(head)
+	ahead, behind := 0, 0
+	for hash := range headAncestors {
+		if !baseAncestors[hash] {
+			ahead++
+		}
+	}
+	for hash := range baseAncestors {
+		if !headAncestors[hash] {
+			behind++
+		}
+	}
+	return ahead, behind
+}
+
+func (self *RepoIndex) refresh(hash plumbing.Hash, when time.Time) {
+	if when.After(self.refreshTime[hash]) {
+		self.refreshTime[hash] = when
+	}
+}
+
+func (self *RepoIndex) scanRefs(repository *git.Repository) error {
+	refIter, err := repository.References()
+	if err != nil {
+		return err
+	}
+	defer refIter.Close()
+
+	return refIter.ForEach(func(ref *plumbing.Reference) error {
+		var shortRef ShortRef
+		shortRef.fromRef(ref)
+		if (shortRef.Type != INVALID_E) && (shortRef.Type != SYMBOLIC_E) && (shortRef.Type != NOTE_E) {
+			var hash plumbing.Hash = ref.Hash()
+			if ref.Name().IsTag() {
+				obj, err := repository.TagObject(hash)
+				switch err {
+				case nil: // This is an annotated tag
+					hash, _, err = peelTag(obj, repository)
+					if err != nil {
+						return err
+					}
+				case plumbing.ErrObjectNotFound:
+				default:
+					return err
+				}
+			}
+			if shortRef.Type == BRANCH_E || shortRef.Type == TAG_E {
+				self.tipRefs[ref.Name().String()] = hash
+			}
+			if val, ok := self.refs[hash]; ok {
+				self.refs[hash] = append(val, shortRef)
+			} else {
+				self.refs[hash] = []ShortRef{shortRef}
+			}
+		}
+		return nil
+	})
+}
+
+// Abbreviated hashes only resolve when they are unambiguous, as with git rev-parse
+func (self *RepoIndex) lookupCommit(prefix string) (plumbing.Hash, bool) {
+	prefix = strings.ToLower(prefix)
+	idx := sort.SearchStrings(self.commits, prefix)
+	if idx == len(self.commits) || !strings.HasPrefix(self.commits[idx], prefix) {
+		return plumbing.ZeroHash, false
+	}
+	if idx+1 < len(self.commits) && strings.HasPrefix(self.commits[idx+1], prefix) {
+		return plumbing.ZeroHash, false
+	}
+	return plumbing.NewHash(self.commits[idx]), true
+}
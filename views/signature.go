@@ -0,0 +1,328 @@
+package views
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgpErrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+type SignatureStatus int8
+
+const (
+	UNSIGNED_E SignatureStatus = iota
+	VERIFIED_E
+	UNVERIFIED_E
+	UNKNOWN_KEY_E
+)
+
+type SignatureData struct {
+	Status SignatureStatus
+	Kind   string
+	Signer string
+}
+
+// This global is treated as a constant and should only be read
+// It acts as a string mapping for each signature enum for the templates
+var signatureFuncMap = template.FuncMap{
+	"SignatureEnumToString": func(enum SignatureStatus) string {
+		var representation string = ""
+		switch enum {
+		case VERIFIED_E:
+			representation = "verifiedSignature"
+		case UNVERIFIED_E:
+			representation = "unverifiedSignature"
+		case UNKNOWN_KEY_E:
+			representation = "unknownKeySignature"
+		}
+		return representation
+	},
+	"SignatureEnumToLabel": func(enum SignatureStatus) string {
+		var label string = ""
+		switch enum {
+		case VERIFIED_E:
+			label = "verified"
+		case UNVERIFIED_E:
+			label = "unverified"
+		case UNKNOWN_KEY_E:
+			label = "unknown key"
+		}
+		return label
+	},
+	"Unsigned": func() SignatureStatus { return UNSIGNED_E },
+}
+
+type allowedSigner struct {
+	Principals []string
+	Key        ssh.PublicKey
+}
+
+// Verifier holds the keys signatures are checked against. Either set of keys may be
+// empty, in which case every signature of that kind is reported as an unknown key.
+type Verifier struct {
+	keyring openpgp.EntityList
+	signers []allowedSigner
+	// When the keys last changed, as pages showing signatures checked against older keys
+	// have to be rewritten
+	modTime time.Time
+}
+
+// The later of the page's own time and the keys' for a page showing signatures
+func (self *Verifier) pageTime(modTime time.Time) time.Time {
+	if self.modTime.After(modTime) {
+		return self.modTime
+	}
+	return modTime
+}
+
+const (
+	pgpSignaturePrefix = "-----BEGIN PGP SIGNATURE-----"
+	sshSignaturePrefix = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureSuffix = "-----END SSH SIGNATURE-----"
+	sshSignatureMagic  = "SSHSIG"
+	// git signs with this namespace, see gpg.ssh.allowedSignersFile in git-config(1)
+	sshGitNamespace = "git"
+)
+
+func newVerifier(config Config) (*Verifier, error) {
+	var verifier Verifier
+	for _, path := range []string{config.GPGKeyring, config.AllowedSigners} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().After(verifier.modTime) {
+			verifier.modTime = info.ModTime()
+		}
+	}
+
+	if config.GPGKeyring != "" {
+		file, err := os.Open(config.GPGKeyring)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		// Both armored and binary keyrings (e.g., from gpg --export) are accepted
+		var buffer bytes.Buffer
+		_, err = buffer.ReadFrom(file)
+		if err != nil {
+			return nil, err
+		}
+		verifier.keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			verifier.keyring, err = openpgp.ReadKeyRing(bytes.NewReader(buffer.Bytes()))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", config.GPGKeyring, err)
+		}
+	}
+
+	if config.AllowedSigners != "" {
+		signers, err := readAllowedSigners(config.AllowedSigners)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", config.AllowedSigners, err)
+		}
+		verifier.signers = signers
+	}
+	return &verifier, nil
+}
+
+// The allowed signers file uses the format described in ssh-keygen(1), that is
+// principals followed by optional options and then the key as in authorized_keys.
+func readAllowedSigners(path string) ([]allowedSigner, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	signers := make([]allowedSigner, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		principals, rest, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(rest)))
+		if err != nil {
+			return nil, err
+		}
+		if !allowsGitNamespace(options) {
+			continue
+		}
+		signers = append(signers, allowedSigner{
+			Principals: strings.Split(strings.Trim(principals, `"`), ","),
+			Key:        key,
+		})
+	}
+	return signers, scanner.Err()
+}
+
+func allowsGitNamespace(options []string) bool {
+	for _, option := range options {
+		name, value, found := strings.Cut(option, "=")
+		if !found || !strings.EqualFold(name, "namespaces") {
+			continue
+		}
+		for _, namespace := range strings.Split(strings.Trim(value, `"`), ",") {
+			if namespace == sshGitNamespace {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (self *Verifier) verifyCommit(commit *object.Commit) (SignatureData, error) {
+	if commit.PGPSignature == "" {
+		return SignatureData{Status: UNSIGNED_E}, nil
+	}
+	encoded := &plumbing.MemoryObject{}
+	err := commit.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return SignatureData{}, err
+	}
+	return self.verify(encoded, commit.PGPSignature)
+}
+
+func (self *Verifier) verifyTag(tag *object.Tag) (SignatureData, error) {
+	if tag.PGPSignature == "" {
+		return SignatureData{Status: UNSIGNED_E}, nil
+	}
+	encoded := &plumbing.MemoryObject{}
+	err := tag.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return SignatureData{}, err
+	}
+	return self.verify(encoded, tag.PGPSignature)
+}
+
+func (self *Verifier) verify(encoded *plumbing.MemoryObject, signature string) (SignatureData, error) {
+	reader, err := encoded.Reader()
+	if err != nil {
+		return SignatureData{}, err
+	}
+	defer reader.Close()
+
+	switch {
+	case strings.HasPrefix(signature, pgpSignaturePrefix):
+		return self.verifyPGP(reader, signature), nil
+	case strings.HasPrefix(signature, sshSignaturePrefix):
+		return self.verifySSH(reader, signature), nil
+	}
+	// X509 and anything else we can't check
+	return SignatureData{Status: UNKNOWN_KEY_E}, nil
+}
+
+func (self *Verifier) verifyPGP(signed io.Reader, signature string) SignatureData {
+	data := SignatureData{Kind: "GPG"}
+	entity, err := openpgp.CheckArmoredDetachedSignature(self.keyring, signed, strings.NewReader(signature), nil)
+	switch {
+	case err == nil:
+		data.Status = VERIFIED_E
+	case errors.Is(err, pgpErrors.ErrUnknownIssuer):
+		data.Status = UNKNOWN_KEY_E
+	default:
+		data.Status = UNVERIFIED_E
+	}
+	if entity != nil {
+		if identity := entity.PrimaryIdentity(); identity != nil {
+			data.Signer = identity.Name
+		}
+	}
+	return data
+}
+
+// See PROTOCOL.sshsig in the OpenSSH sources for the format being checked here
+func (self *Verifier) verifySSH(signed io.Reader, signature string) SignatureData {
+	data := SignatureData{Kind: "SSH", Status: UNVERIFIED_E}
+
+	armored := strings.TrimPrefix(strings.TrimSpace(signature), sshSignaturePrefix)
+	armored = strings.TrimSuffix(armored, sshSignatureSuffix)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored), ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return data
+	}
+
+	var envelope struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	err = ssh.Unmarshal(blob[len(sshSignatureMagic):], &envelope)
+	if err != nil || envelope.Version != 1 || envelope.Namespace != sshGitNamespace {
+		return data
+	}
+	key, err := ssh.ParsePublicKey(envelope.PublicKey)
+	if err != nil {
+		return data
+	}
+	var sshSignature ssh.Signature
+	err = ssh.Unmarshal(envelope.Signature, &sshSignature)
+	if err != nil {
+		return data
+	}
+
+	var hasher hash.Hash
+	switch envelope.HashAlgorithm {
+	case "sha256":
+		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
+	default:
+		return data
+	}
+	_, err = io.Copy(hasher, signed)
+	if err != nil {
+		return data
+	}
+
+	message := []byte(sshSignatureMagic)
+	message = append(message, ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{envelope.Namespace, envelope.Reserved, envelope.HashAlgorithm, hasher.Sum(nil)})...)
+	if key.Verify(message, &sshSignature) != nil {
+		return data
+	}
+
+	data.Status = UNKNOWN_KEY_E
+	data.Signer = ssh.FingerprintSHA256(key)
+	for _, signer := range self.signers {
+		if bytes.Equal(signer.Key.Marshal(), key.Marshal()) {
+			data.Status = VERIFIED_E
+			data.Signer = strings.Join(signer.Principals, ", ")
+			break
+		}
+	}
+	return data
+}
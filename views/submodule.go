@@ -0,0 +1,146 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Where the pages of another repository generated with this tool are served, either
+// absolute or relative to the output root, for submodules with the given URL
+type SubmoduleSite struct {
+	URL   string
+	Pages string
+}
+
+// Hosts whose commit pages are known, keyed by host with the path to append to the
+// repository's URL before the hash
+var commitPathByHost = map[string]string{
+	"github.com":    "/tree/",
+	"gitlab.com":    "/-/tree/",
+	"codeberg.org":  "/src/commit/",
+	"bitbucket.org": "/src/",
+}
+
+type submoduleLinker struct {
+	// The URLs from .gitmodules keyed by submodule path
+	urls map[string]string
+	// The URL relative submodule URLs are resolved against
+	remoteURL string
+	sites     []SubmoduleSite
+}
+
+func newSubmoduleLinker(tree *object.Tree, index *RepoIndex, sites []SubmoduleSite) (*submoduleLinker, error) {
+	urls, err := getSubmoduleNameUrlMap(tree)
+	if err != nil {
+		return nil, err
+	}
+	return &submoduleLinker{urls, index.remoteURL, sites}, nil
+}
+
+// The URL of the origin remote which relative submodule URLs are resolved against
+func findRemoteURL(repository *git.Repository) (string, error) {
+	remote, err := repository.Remote(git.DefaultRemoteName)
+	if errors.Is(err, git.ErrRemoteNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if urls := remote.Config().URLs; len(urls) != 0 {
+		return urls[0], nil
+	}
+	return "", nil
+}
+
+// Clone URLs are turned into the https URL a browser can open, e.g., git@host:user/repo.git
+// and ssh://git@host/user/repo.git both give https://host/user/repo, while local paths
+// give nothing
+func browsableURL(rawURL string, remoteURL string) string {
+	if strings.HasPrefix(rawURL, "./") || strings.HasPrefix(rawURL, "../") {
+		base := browsableURL(remoteURL, "")
+		if base == "" {
+			return ""
+		}
+		baseURL, err := url.Parse(base + "/")
+		if err != nil {
+			return ""
+		}
+		relative, err := url.Parse(rawURL)
+		if err != nil {
+			return ""
+		}
+		return browsableURL(baseURL.ResolveReference(relative).String(), "")
+	}
+
+	var host, repoPath string
+	if scheme, rest, found := strings.Cut(rawURL, "://"); found {
+		switch scheme {
+		case "http", "https", "ssh", "git", "git+ssh", "ssh+git":
+		default:
+			return ""
+		}
+		parsed, err := url.Parse("ssh://" + rest)
+		if err != nil {
+			return ""
+		}
+		if scheme == "http" {
+			host = "http://" + parsed.Host
+		} else {
+			host = "https://" + parsed.Hostname()
+		}
+		repoPath = parsed.Path
+	} else if colon := strings.Index(rawURL, ":"); colon != -1 && !strings.Contains(rawURL[:colon], "/") {
+		// The scp-like syntax of user@host:path
+		hostPart := rawURL[:colon]
+		if at := strings.LastIndex(hostPart, "@"); at != -1 {
+			hostPart = hostPart[at+1:]
+		}
+		host = "https://" + hostPart
+		repoPath = "/" + strings.TrimPrefix(rawURL[colon+1:], "/")
+	} else {
+		return ""
+	}
+	repoPath = strings.TrimSuffix(strings.TrimSuffix(path.Clean(repoPath), "/"), ".git")
+	return host + repoPath
+}
+
+// Sites which aren't absolute are relative to the output root and reported as local
+func isAbsoluteLink(link string) bool {
+	return strings.HasPrefix(link, "/") || strings.Contains(link, "://")
+}
+
+// The page for the pinned commit of the submodule at path, which is on the site of
+// the submodule when it was generated too, and otherwise on its host when that is known
+func (self *submoduleLinker) link(submodulePath string, hash plumbing.Hash) (string, bool) {
+	if self == nil {
+		return "", false
+	}
+	rawURL, ok := self.urls[submodulePath]
+	if !ok {
+		return "", false
+	}
+	browsable := browsableURL(rawURL, self.remoteURL)
+	for _, site := range self.sites {
+		if site.URL == rawURL || (browsable != "" && browsableURL(site.URL, self.remoteURL) == browsable) {
+			link := fmt.Sprintf("%s/c/%s.html", strings.TrimSuffix(site.Pages, "/"), hash)
+			return link, !isAbsoluteLink(site.Pages)
+		}
+	}
+	if browsable == "" {
+		return "", false
+	}
+	parsed, err := url.Parse(browsable)
+	if err != nil {
+		return "", false
+	}
+	if commitPath, ok := commitPathByHost[parsed.Hostname()]; ok {
+		return browsable + commitPath + hash.String(), false
+	}
+	return browsable, false
+}
@@ -0,0 +1,102 @@
+package views
+
+import (
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// As with Linux, chains of more links than this are treated as loops
+const maxSymlinkHops = 40
+
+// Where a symlink points, where Resolved is the path of the target in the tree and
+// Href is the target's page relative to the page showing the link
+type SymlinkData struct {
+	Target   string
+	Resolved string
+	Href     string
+	Dangling bool
+	Escapes  bool
+	isDir    bool
+}
+
+func readSymlink(root *object.Tree, entry *object.TreeEntry) (string, error) {
+	file, err := root.TreeEntryFile(entry)
+	if err != nil {
+		return "", err
+	}
+	return file.Contents()
+}
+
+// Targets are resolved like the filesystem would, following links in any component,
+// so a link may escape the repository or dangle part way through its target
+func resolveSymlink(root *object.Tree, linkPath string, target string) SymlinkData {
+	data := SymlinkData{Target: target}
+	if path.IsAbs(target) {
+		data.Escapes = true
+		return data
+	}
+
+	current := path.Join(path.Dir(linkPath), target)
+	for hops := 0; ; hops++ {
+		if current == ".." || strings.HasPrefix(current, "../") {
+			data.Escapes = true
+			return data
+		}
+		if current == "." {
+			return data
+		}
+
+		parts := strings.Split(current, "/")
+		resolved := ""
+		restarted := false
+		var last *object.TreeEntry = nil
+		for idx, part := range parts {
+			candidate := path.Join(resolved, part)
+			entry, err := root.FindEntry(candidate)
+			if err != nil {
+				data.Dangling = true
+				return data
+			}
+			if entry.Mode == filemode.Symlink {
+				linkTarget, err := readSymlink(root, entry)
+				if err != nil || hops >= maxSymlinkHops {
+					data.Dangling = true
+					return data
+				}
+				if path.IsAbs(linkTarget) {
+					data.Escapes = true
+					return data
+				}
+				current = path.Join(append([]string{path.Dir(candidate), linkTarget}, parts[idx+1:]...)...)
+				restarted = true
+				break
+			}
+			if idx+1 < len(parts) && entry.Mode != filemode.Dir {
+				data.Dangling = true
+				return data
+			}
+			resolved = candidate
+			last = entry
+		}
+		if !restarted {
+			// Submodules have no page to link to
+			if last.Mode != filemode.Submodule {
+				data.Resolved = resolved
+				data.isDir = last.Mode == filemode.Dir
+			}
+			return data
+		}
+	}
+}
+
+// The target's page when the page showing the link is in the directory treeBase
+// relative to the tree's pages and the target has a page of its own
+func (data *SymlinkData) link(treeBase string, pathFilter Filter) {
+	if data.Resolved == "" || !pathFilter.AllowsPath(data.Resolved, data.isDir) {
+		return
+	}
+	data.Href = treeBase + treePage(data.Resolved)
+}
@@ -0,0 +1,133 @@
+package views
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// Rows past this are left to the source view so that huge data files don't make huge pages
+const maxTableRows = 1000
+
+var tableSeparators = map[string]rune{
+	".csv": ',',
+	".tsv": '\t',
+}
+
+type TableCell struct {
+	Text    string
+	Numeric bool
+}
+
+type TableData struct {
+	Header []TableCell
+	Rows   [][]TableCell
+	// The number of rows past maxTableRows which aren't shown
+	Omitted int
+}
+
+func tableSeparator(name string) (rune, bool) {
+	separator, ok := tableSeparators[strings.ToLower(path.Ext(name))]
+	return separator, ok
+}
+
+func isNumeric(text string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	return err == nil
+}
+
+// The first row is taken as the header when none of its cells are empty or numbers, and
+// columns whose cells are all numbers or empty, though not all empty, are right aligned
+func (data *TableData) fromFile(file *object.File, separator rune) (err error) {
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(reader, &err)
+
+	records := csv.NewReader(reader)
+	records.Comma = separator
+	records.FieldsPerRecord = -1
+	records.LazyQuotes = true
+	var rows [][]string
+	for {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(rows) > maxTableRows {
+			data.Omitted++
+			continue
+		}
+		rows = append(rows, record)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	hasHeader := true
+	for _, cell := range rows[0] {
+		if strings.TrimSpace(cell) == "" || isNumeric(cell) {
+			hasHeader = false
+			break
+		}
+	}
+	var header []string
+	if hasHeader {
+		header = rows[0]
+		rows = rows[1:]
+	}
+	if len(rows) > maxTableRows {
+		data.Omitted += len(rows) - maxTableRows
+		rows = rows[:maxTableRows]
+	}
+
+	columns := len(header)
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	numeric := make([]bool, columns)
+	for column := range numeric {
+		empty := true
+		numeric[column] = true
+		for _, row := range rows {
+			if column >= len(row) || strings.TrimSpace(row[column]) == "" {
+				continue
+			}
+			empty = false
+			if !isNumeric(row[column]) {
+				numeric[column] = false
+				break
+			}
+		}
+		numeric[column] = numeric[column] && !empty
+	}
+
+	// Short rows are padded so that every row has a cell in each column
+	toCells := func(row []string) []TableCell {
+		cells := make([]TableCell, columns)
+		for column := range cells {
+			cells[column].Numeric = numeric[column]
+			if column < len(row) {
+				cells[column].Text = row[column]
+			}
+		}
+		return cells
+	}
+	if hasHeader {
+		data.Header = toCells(header)
+	}
+	data.Rows = make([][]TableCell, 0, len(rows))
+	for _, row := range rows {
+		data.Rows = append(data.Rows, toCells(row))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Paths in a tree are escaped a component at a time so that their pages can't collide, even
+// on case-insensitive filesystems, and so that the escaped path is safe to use as an href.
+// Lowercase letters, digits and "-._+,=@" are kept, uppercase letters are written as '!'
+// followed by the lowercase letter, as Go's module cache does, and every other byte becomes '~'
+// followed by its value in lowercase hex. The final '.' of a name ending in ".html" is escaped
+// as well so that the folder of a directory never shares its name with the page of a file.
+func escapeTreeName(name string) string {
+	var sb strings.Builder
+	for idx := 0; idx < len(name); idx++ {
+		c := name[idx]
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', strings.IndexByte("-._+,=@", c) >= 0:
+			if c == '.' && name[idx:] == ".html" {
+				fmt.Fprintf(&sb, "~%02x", c)
+			} else {
+				sb.WriteByte(c)
+			}
+		case 'A' <= c && c <= 'Z':
+			sb.WriteByte('!')
+			sb.WriteByte(c - 'A' + 'a')
+		default:
+			fmt.Fprintf(&sb, "~%02x", c)
+		}
+	}
+	return sb.String()
+}
+
+func escapeTreePath(name string) string {
+	parts := strings.Split(name, "/")
+	for idx, part := range parts {
+		parts[idx] = escapeTreeName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// The page of the file or directory at name relative to the tree's pages
+func treePage(name string) string {
+	return escapeTreePath(name) + ".html"
+}
@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
+	"strings"
 	"time"
 
 	git "github.com/go-git/go-git/v5"
@@ -17,7 +18,7 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-func WriteCommits(repository *git.Repository, repositoryName string, baseDir string, config Config) error {
+func WriteCommits(repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
 	commitDir := filepath.Join(baseDir, "c")
 	err := os.MkdirAll(commitDir, 0755)
 	if err != nil {
@@ -33,58 +34,11 @@ func WriteCommits(repository *git.Repository, repositoryName string, baseDir str
 	}
 	defer commitIter.Close()
 
-	// This iterator is over the notes "branches" e.g., refs/notes/commits
-	noteIter, err := repository.Notes()
-	if err != nil {
-		return err
-	}
-	defer noteIter.Close()
-
-	var noteMap NoteMap = make(NoteMap)
-	err = noteIter.ForEach(func(note *plumbing.Reference) error {
-		commit, err := repository.CommitObject(note.Hash())
-		if err != nil { // plumbing.ErrObjectNotFound is included here
-			return err
-		}
-
-		// Notes consist of a blob
-		fileIter, err := commit.Files()
-		if err != nil {
-			return err
-		}
-		err = fileIter.ForEach(func(file *object.File) error {
-			var blobData BlobData
-			err := blobData.fromFile(file)
-			if err != nil {
-				return err
-			}
-			noteData := NoteData{
-				Reference: string(note.Name()),
-				Hash:      commit.Hash,
-				Time:      commit.Committer.When,
-				Blob:      blobData,
-			}
-
-			commitHash := file.Name
-			if val, ok := noteMap[commitHash]; ok {
-				noteMap[commitHash] = append(val, noteData)
-			} else {
-				noteMap[commitHash] = []NoteData{noteData}
-			}
-			return nil
-		})
-
-		return err
-	})
-	if err != nil {
-		return err
-	}
-
 	err = commitIter.ForEach(func(commit *object.Commit) error {
 		fileName := fmt.Sprintf("%s.html", commit.Hash)
 		commitPath := filepath.Join(commitDir, fileName)
 
-		notes := noteMap[fmt.Sprintf("%s", commit.Hash)]
+		notes := index.notes[commit.Hash.String()]
 		noteTime := recentNoteTime(notes)
 		var modTime time.Time
 		if noteTime.After(commit.Committer.When) {
@@ -92,6 +46,13 @@ func WriteCommits(repository *git.Repository, repositoryName string, baseDir str
 		} else {
 			modTime = commit.Committer.When
 		}
+		// New children and refs containing the commit change its page too
+		if refreshTime := index.refreshTime[commit.Hash]; refreshTime.After(modTime) {
+			modTime = refreshTime
+		}
+		if commit.PGPSignature != "" {
+			modTime = index.verifier.pageTime(modTime)
+		}
 
 		skip, err := isSkipWrite(commitPath, modTime)
 		if err != nil {
@@ -112,19 +73,124 @@ func WriteCommits(repository *git.Repository, repositoryName string, baseDir str
 				Branch: "",
 			},
 		}
+		// TODO: We should combine diffs for a merge. How should this be done?
+		changes, patch, err := patchFromCommit(commit)
+		if err != nil {
+			return err
+		}
+
+		// The full patch is always written so that suppressed diffs can link to it
+		var diffBuffer bytes.Buffer
+		err = patch.Encode(&diffBuffer)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(commitDir, fmt.Sprintf("%s.diff", commit.Hash)), diffBuffer.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+
+		var patchBuffer bytes.Buffer
+		err = formatPatch(commit, patch, &patchBuffer)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(commitDir, fmt.Sprintf("%s.patch", commit.Hash)), patchBuffer.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+
+		var data CommitData
 		// PERFORMANCE: Calling stats for every commit is expensive.
-		err = generateCommit(commit, notes, commitBase, &buffer)
+		err = data.fromCommit(commit, changes, patch, index.verifier, index, config)
+		if err != nil {
+			return err
+		}
+		data.Notes = notes
+		data.Containing = index.containingRefs(commit.Hash)
+		data.Next = index.next(commit.Hash)
+		if config.CommitFiles {
+			data.Files = fmt.Sprintf("%s/", commit.Hash)
+		}
+
+		err = generateCommit(data, index, config.Autolinks, commitBase, &buffer)
 		if err != nil {
 			return err
 		}
 		err = writeHtml(&buffer, commitPath)
-		return err
+		if err != nil {
+			return err
+		}
+
+		if !config.CommitFiles {
+			return nil
+		}
+		return WriteCommitFiles(commit, linkedPaths(data.Lines), repositoryName, commitDir, config)
 	})
 
 	return err
 }
 
-func WriteIndex(branch *object.Commit, repository *git.Repository, repositoryName string, hash plumbing.Hash, branchDir string, branchName string, treePrefix string, config Config) error {
+// The files changed by a commit get a page as they were at that commit so that
+// the line numbers in its diff have somewhere to link to. Only the files whose diff
+// is shown and which the path filter allows get one, so the diff limits bound how many
+// are written.
+func WriteCommitFiles(commit *object.Commit, paths []string, repositoryName string, commitDir string, config Config) error {
+	fileDir := filepath.Join(commitDir, fmt.Sprintf("%s", commit.Hash))
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	for _, name := range paths {
+		file, err := commit.File(name)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(fileDir, filepath.FromSlash(treePage(name)))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+
+		var fileBuffer bytes.Buffer
+		root := relRootFromPath(path)
+		fileBase := BaseData{
+			Title:     name,
+			StylePath: root + config.StylePath,
+			Home:      repositoryName,
+			Root:      root,
+			Nav: NavData{
+				Commit: fmt.Sprintf("%s", commit.Hash),
+				Branch: "",
+				// Only the changed files have pages, so the directories aren't linked
+				Breadcrumbs: treeBreadcrumbs(repositoryName, root, fmt.Sprintf("%.7s", commit.Hash), fmt.Sprintf("../%s.html", commit.Hash), name, false),
+			},
+		}
+		var symlink *SymlinkData = nil
+		if file.Mode == filemode.Symlink {
+			target, err := file.Contents()
+			if err != nil {
+				return err
+			}
+			// Only the files the commit changed have pages, so the target isn't linked
+			resolved := resolveSymlink(tree, name, target)
+			symlink = &resolved
+		}
+		err = generateBlob(file, symlink, nil, nil, fileBase, &fileBuffer)
+		if err != nil {
+			return err
+		}
+
+		err = writeHtml(&fileBuffer, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteIndex(branch *object.Commit, repository *git.Repository, index *RepoIndex, repositoryName string, hash plumbing.Hash, branchDir string, branchName string, treePrefix string, config Config) error {
 	var branchBuffer bytes.Buffer
 	branchPath := filepath.Join(branchDir, "index.html")
 
@@ -135,16 +201,21 @@ func WriteIndex(branch *object.Commit, repository *git.Repository, repositoryNam
 		Home:      repositoryName,
 		Root:      root,
 		Nav: NavData{
-			Commit: fmt.Sprintf("%s", hash),
-			Branch: branchName,
+			Commit:      fmt.Sprintf("%s", hash),
+			Branch:      branchName,
+			Breadcrumbs: treeBreadcrumbs(repositoryName, root, branchName, "", "", true),
 		},
 	}
-	submoduleMap, err := getSubmoduleNameUrlMap(branch, repository)
+	tree, err := branch.Tree()
+	if err != nil {
+		return err
+	}
+	context, err := newTreeContext(repository, tree, branch, index, config)
 	if err != nil {
 		return err
 	}
 
-	err = generateIndex(branch, submoduleMap, treePrefix, branchBase, &branchBuffer)
+	err = generateIndex(context, treePrefix, branchBase, &branchBuffer)
 	if err != nil {
 		return err
 	}
@@ -157,10 +228,10 @@ func WriteIndex(branch *object.Commit, repository *git.Repository, repositoryNam
 	return nil
 }
 
-func WriteLog(branch *object.Commit, repository *git.Repository, repositoryName string, hash plumbing.Hash, branchDir string, branchName string, config Config) error {
+func WriteLog(branch *object.Commit, repository *git.Repository, index *RepoIndex, repositoryName string, hash plumbing.Hash, branchDir string, branchName string, config Config) error {
 	var logBuffer bytes.Buffer
 	logPath := filepath.Join(branchDir, "log.html")
-	skip, err := isSkipWrite(logPath, branch.Committer.When)
+	skip, err := isSkipWrite(logPath, index.verifier.pageTime(branch.Committer.When))
 	if err != nil {
 		return err
 	}
@@ -180,39 +251,7 @@ func WriteLog(branch *object.Commit, repository *git.Repository, repositoryName
 		},
 	}
 
-	var refs = make(map[plumbing.Hash][]ShortRef)
-	refIter, err := repository.References()
-	if err != nil {
-		return err
-	}
-	err = refIter.ForEach(func(ref *plumbing.Reference) error {
-		var shortRef ShortRef
-		shortRef.fromRef(ref)
-		if (shortRef.Type != INVALID_E) && (shortRef.Type != SYMBOLIC_E) && (shortRef.Type != NOTE_E) {
-			var hash plumbing.Hash = ref.Hash()
-			if ref.Name().IsTag() {
-				obj, err := repository.TagObject(hash)
-				switch err {
-				case nil: // This is an annotated tag
-					hash = obj.Target
-				case plumbing.ErrObjectNotFound:
-				default:
-					return err
-				}
-			}
-			if val, ok := refs[hash]; ok {
-				refs[hash] = append(val, shortRef)
-			} else {
-				refs[hash] = []ShortRef{shortRef}
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-
-	err = generateLog(branch, refs, logBase, &logBuffer, config.LogLimit)
+	err = generateLog(branch, index.verifier, index, config.Autolinks, logBase, &logBuffer, config.LogLimit)
 	if err != nil {
 		return err
 	}
@@ -225,18 +264,28 @@ func WriteLog(branch *object.Commit, repository *git.Repository, repositoryName
 	return nil
 }
 
-func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName string, treeDir string, branchName string, config Config) error {
+func WriteTree(branch *object.Commit, repository *git.Repository, index *RepoIndex, repositoryName string, treeDir string, branchName string, config Config) error {
 	// Generate the pages for each file/dir in the branch
 	tree, err := branch.Tree()
 	if err != nil {
 		return err
 	}
-	walker := object.NewTreeWalker(tree, true, nil)
-	defer walker.Close()
-	submoduleMap, err := getSubmoduleNameUrlMap(branch, repository)
+	context, err := newTreeContext(repository, tree, branch, index, config)
 	if err != nil {
 		return err
 	}
+	return writeTreePages(context, index, repositoryName, treeDir, branchName, branchName, config)
+}
+
+// The raw files of a tree are written beside its pages, in this directory
+const rawDir = "raw"
+
+// Tree pages are written for branches and for tags which point directly to a tree, and
+// their breadcrumbs lead back to the branch's or tag's page under topName
+func writeTreePages(context *treeContext, index *RepoIndex, repositoryName string, treeDir string, branchName string, topName string, config Config) error {
+	tree := context.root
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
 
 	threadGroup := new(errgroup.Group)
 	for {
@@ -247,6 +296,9 @@ func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName
 		if err != nil {
 			return err
 		}
+		if !config.PathFilter.AllowsPath(name, entry.Mode == filemode.Dir) {
+			continue
+		}
 
 		switch entry.Mode {
 		case filemode.Dir:
@@ -256,7 +308,7 @@ func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName
 				return err
 			}
 
-			folderPath := filepath.Join(treeDir, name)
+			folderPath := filepath.Join(treeDir, filepath.FromSlash(escapeTreePath(name)))
 			htmlPath := folderPath + ".html"
 
 			err = os.MkdirAll(folderPath, 0755)
@@ -272,12 +324,13 @@ func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName
 				Home:      repositoryName,
 				Root:      root,
 				Nav: NavData{
-					Commit: "",
-					Branch: branchName,
+					Commit:      "",
+					Branch:      branchName,
+					Breadcrumbs: treeBreadcrumbs(repositoryName, root, topName, "../index.html", name, true),
 				},
 			}
 
-			err = generateTree(subTree, submoduleMap, treeName, treeBase, &treeBuffer)
+			err = generateTree(context, subTree, treeName, name, treeBase, &treeBuffer)
 			if err != nil {
 				return err
 			}
@@ -294,11 +347,29 @@ func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName
 			if err != nil {
 				return err
 			}
+			fileContext, err := context.copyForGoroutine()
+			if err != nil {
+				return err
+			}
+			// The page of a/b is a/b.html, which is one directory below the tree's pages
+			treeBase := strings.Repeat("../", strings.Count(name, "/"))
+			// Symlinks are resolved here as finding their target walks the shared root
+			var symlink *SymlinkData = nil
+			if entry.Mode == filemode.Symlink {
+				target, err := file.Contents()
+				if err != nil {
+					return err
+				}
+				resolved := resolveSymlink(tree, name, target)
+				resolved.link(treeBase, config.PathFilter)
+				symlink = &resolved
+			}
 
 			threadGroup.Go(func() error {
+				links := &markdownLinks{fileContext, path.Dir(name), treeBase}
 				var fileBuffer bytes.Buffer
 
-				path := filepath.Join(treeDir, name+".html")
+				path := filepath.Join(treeDir, filepath.FromSlash(treePage(name)))
 				root := relRootFromPath(path)
 				fileBase := BaseData{
 					Title:     name,
@@ -306,11 +377,20 @@ func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName
 					Home:      repositoryName,
 					Root:      root,
 					Nav: NavData{
-						Commit: "",
-						Branch: branchName,
+						Commit:      "",
+						Branch:      branchName,
+						Breadcrumbs: treeBreadcrumbs(repositoryName, root, topName, "../index.html", name, true),
 					},
 				}
-				err = generateBlob(file, fileBase, &fileBuffer)
+				if symlink == nil {
+					// Markdown links images to the raw files rather than their pages
+					rawPath := filepath.Join(treeDir, "..", rawDir, filepath.FromSlash(escapeTreePath(name)))
+					err = writeRaw(file, rawPath)
+					if err != nil {
+						return err
+					}
+				}
+				err = generateBlob(file, symlink, links, index.notes[file.Hash.String()], fileBase, &fileBuffer)
 				if err != nil {
 					return err
 				}
@@ -325,11 +405,11 @@ func WriteTree(branch *object.Commit, repository *git.Repository, repositoryName
 	return threadGroup.Wait()
 }
 
-func WriteBranch(branch *plumbing.Reference, repository *git.Repository, repositoryName string, baseDir string, config Config) error {
+func WriteBranch(branch *plumbing.Reference, repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
 	const treePrefix = "t"
 
-	branchName := filepath.Base(string(branch.Name()))
-	branchDir := filepath.Join(baseDir, branchName)
+	branchName := filepath.ToSlash(refDir(branch.Name()))
+	branchDir := filepath.Join(baseDir, refDir(branch.Name()))
 	treeDir := filepath.Join(branchDir, treePrefix)
 	err := os.MkdirAll(treeDir, 0755)
 	if err != nil {
@@ -341,17 +421,17 @@ func WriteBranch(branch *plumbing.Reference, repository *git.Repository, reposit
 		return err
 	}
 
-	err = WriteIndex(commit, repository, repositoryName, branch.Hash(), branchDir, branchName, treePrefix, config)
+	err = WriteIndex(commit, repository, index, repositoryName, branch.Hash(), branchDir, branchName, treePrefix, config)
 	if err != nil {
 		return err
 	}
 
-	err = WriteLog(commit, repository, repositoryName, branch.Hash(), branchDir, branchName, config)
+	err = WriteLog(commit, repository, index, repositoryName, branch.Hash(), branchDir, branchName, config)
 	if err != nil {
 		return err
 	}
 
-	err = WriteTree(commit, repository, repositoryName, treeDir, branchName, config)
+	err = WriteTree(commit, repository, index, repositoryName, treeDir, branchName, config)
 	if err != nil {
 		return err
 	}
@@ -359,7 +439,53 @@ func WriteBranch(branch *plumbing.Reference, repository *git.Repository, reposit
 	return nil
 }
 
-func WriteRefs(repository *git.Repository, repositoryName string, baseDir string, config Config) error {
+// HEAD usually points to the default branch, but bare mirrors may have it point to a
+// branch which doesn't exist, so the usual names are tried after it
+func findDefaultBranch(repository *git.Repository) (*plumbing.Reference, error) {
+	candidates := make([]plumbing.ReferenceName, 0)
+	head, err := repository.Reference(plumbing.HEAD, false)
+	if err == nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		candidates = append(candidates, head.Target())
+	} else if err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+	candidates = append(candidates, plumbing.NewBranchReferenceName("main"), plumbing.NewBranchReferenceName("master"))
+
+	for _, name := range candidates {
+		ref, err := repository.Reference(name, true)
+		if err == nil {
+			return ref, nil
+		} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// The default branch unless it's filtered out and has no pages
+func homeBranch(index *RepoIndex, config Config) *plumbing.Reference {
+	if index.defaultBranch == nil || !config.BranchFilter.Allows(index.defaultBranch.Name().Short()) {
+		return nil
+	}
+	return index.defaultBranch
+}
+
+// The landing page shows the default branch's tree and README with links into the
+// branch's folder, while repositories without one land on the references instead
+func WriteHome(repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
+	home := homeBranch(index, config)
+	if home == nil {
+		return nil
+	}
+	branchName := refDir(home.Name())
+	commit, err := repository.CommitObject(home.Hash())
+	if err != nil {
+		return err
+	}
+	return WriteIndex(commit, repository, index, repositoryName, commit.Hash, baseDir, branchName, path.Join(branchName, "t"), config)
+}
+
+func WriteRefs(repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
 	refsPath := filepath.Join(baseDir, "refs.html")
 
 	branchIter, err := repository.Branches()
@@ -367,30 +493,34 @@ func WriteRefs(repository *git.Repository, repositoryName string, baseDir string
 		return err
 	}
 	defer branchIter.Close()
-
-	branches := make([]string, 0)
-	_ = branchIter.ForEach(func(branch *plumbing.Reference) error {
-		branches = append(branches, branch.Name().Short())
+	branchRefs := make([]*plumbing.Reference, 0)
+	err = branchIter.ForEach(func(branch *plumbing.Reference) error {
+		if config.BranchFilter.Allows(branch.Name().Short()) {
+			branchRefs = append(branchRefs, branch)
+		}
 		return nil
 	})
-
-	tagIter, err := repository.Tags()
 	if err != nil {
 		return err
 	}
-	defer tagIter.Close()
+	branches, err := collectBranches(branchRefs, repository, index, config)
+	if err != nil {
+		return err
+	}
 
-	tags := make(TagDataSlice, 0)
-	err = tagIter.ForEach(func(tag *plumbing.Reference) error {
-		var data TagData
-		err = data.fromRefSwitch(tag, repository)
-		tags = append(tags, data)
+	namespaceRefs, err := CollectNamespaceRefs(repository, config)
+	if err != nil {
 		return err
-	})
+	}
+	namespaceBranches, err := collectBranches(namespaceRefs, repository, index, config)
+	if err != nil {
+		return err
+	}
+
+	tags, err := collectTags(repository, index.verifier)
 	if err != nil {
 		return err
 	}
-	sort.Sort(sort.Reverse(tags))
 
 	root := relRootFromPath(refsPath)
 	refBase := BaseData{
@@ -405,11 +535,285 @@ func WriteRefs(repository *git.Repository, repositoryName string, baseDir string
 	}
 
 	var refsBuffer bytes.Buffer
-	err = generateRefs(&branches, &tags, refBase, &refsBuffer)
+	err = generateRefs(&branches, &namespaceBranches, &tags, index.defaultBranch != nil, refBase, &refsBuffer)
 	if err != nil {
 		return err
 	}
 
 	err = writeHtml(&refsBuffer, refsPath)
-	return err
+	if err != nil {
+		return err
+	}
+	if homeBranch(index, config) == nil {
+		err = writeHtml(&refsBuffer, filepath.Join(baseDir, "index.html"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for idx, tag := range tags {
+		err = WriteTag(tag, tags.previous(idx), repository, index, repositoryName, baseDir, config)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteTag(tag TagData, previous *TagData, repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
+	tagPath := filepath.Join(baseDir, filepath.FromSlash(tagPage(tag.Name)))
+	data := TagPageData{
+		Tag:     tag,
+		Message: mdBytesToHtml([]byte(tag.Message), nil),
+		Notes:   index.notes[tag.Hash.String()],
+	}
+	// Notes on tagged commits are on the commit page, but trees and blobs have no page
+	if tag.Object != tag.Hash && tag.ObjectType != plumbing.CommitObject {
+		data.Notes = append(data.Notes, index.notes[tag.Object.String()]...)
+	}
+
+	// Like the commit pages, the page and the pages of a tagged tree or blob are only
+	// rewritten when the tag or its notes are newer, so a tag added with an older date
+	// doesn't change the shortlog of the tag after it until that page is removed
+	modTime := tag.Date
+	if noteTime := recentNoteTime(data.Notes); noteTime.After(modTime) {
+		modTime = noteTime
+	}
+	if tag.Signature.Status != UNSIGNED_E {
+		modTime = index.verifier.pageTime(modTime)
+	}
+	skip, err := isSkipWrite(tagPath, modTime)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(tagPath), 0755)
+	if err != nil {
+		return err
+	}
+	if !tag.Target.IsZero() {
+		var since plumbing.Hash
+		if previous != nil {
+			data.Previous = previous.Name
+			since = previous.Target
+		}
+		data.Shortlog, err = shortlogBetween(repository, index, tag.Target, since)
+		if err != nil {
+			return err
+		}
+
+	}
+
+	root := relRootFromPath(tagPath)
+	var tree *object.Tree = nil
+	switch tag.ObjectType {
+	case plumbing.CommitObject:
+		commit, err := repository.CommitObject(tag.Target)
+		if err != nil {
+			return err
+		}
+		tree, err = commit.Tree()
+		if err != nil {
+			return err
+		}
+	case plumbing.TreeObject, plumbing.BlobObject:
+		objectDir := strings.TrimSuffix(tagPath, ".html")
+		data.ObjectPage = root + strings.TrimSuffix(tagPage(tag.Name), ".html") + "/index.html"
+		tree, err = writeTaggedObject(tag, repository, index, repositoryName, objectDir, config)
+		if err != nil {
+			return err
+		}
+	}
+	if tree != nil {
+		prefix := fmt.Sprintf("%s-%s", strings.ReplaceAll(repositoryName, " ", "-"), filepath.Base(tag.Name))
+		data.Archives, err = writeArchives(tree, prefix, tag.Date, strings.TrimSuffix(tagPath, ".html"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.CompareTags && data.Previous != "" {
+		data.Compare = root + filepath.ToSlash(comparePath(ComparePair{Base: data.Previous, Head: tag.Name}))
+	}
+	tagBase := BaseData{
+		Title:     tag.Name,
+		StylePath: root + config.StylePath,
+		Home:      repositoryName,
+		Root:      root,
+		Nav: NavData{
+			Commit: "",
+			Branch: "",
+		},
+	}
+
+	var tagBuffer bytes.Buffer
+	err = generateTag(data, tagBase, &tagBuffer)
+	if err != nil {
+		return err
+	}
+	return writeHtml(&tagBuffer, tagPath)
+}
+
+// Tagged trees are rendered like a branch's tree and tagged blobs as a single file page,
+// both with objectDir/index.html as their entry point
+func writeTaggedObject(tag TagData, repository *git.Repository, index *RepoIndex, repositoryName string, objectDir string, config Config) (*object.Tree, error) {
+	const treePrefix = "t"
+
+	err := os.MkdirAll(objectDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	objectPath := filepath.Join(objectDir, "index.html")
+	root := relRootFromPath(objectPath)
+	objectBase := BaseData{
+		Title:     tag.Name,
+		StylePath: root + config.StylePath,
+		Home:      repositoryName,
+		Root:      root,
+		Nav: NavData{
+			Commit:      "",
+			Branch:      "",
+			Breadcrumbs: treeBreadcrumbs(repositoryName, root, tag.Name, "", "", true),
+		},
+	}
+
+	var objectBuffer bytes.Buffer
+	var tree *object.Tree = nil
+	if tag.ObjectType == plumbing.TreeObject {
+		tree, err = repository.TreeObject(tag.Object)
+		if err != nil {
+			return nil, err
+		}
+		context, err := newTreeContext(repository, tree, nil, index, config)
+		if err != nil {
+			return nil, err
+		}
+		err = generateIndex(context, treePrefix, objectBase, &objectBuffer)
+		if err != nil {
+			return nil, err
+		}
+		treeDir := filepath.Join(objectDir, treePrefix)
+		err = os.MkdirAll(treeDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = writeTreePages(context, index, repositoryName, treeDir, "", tag.Name, config)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		blob, err := repository.BlobObject(tag.Object)
+		if err != nil {
+			return nil, err
+		}
+		file := object.NewFile(tag.Name, filemode.Regular, blob)
+		err = generateBlob(file, nil, nil, index.notes[tag.Object.String()], objectBase, &objectBuffer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tree, writeHtml(&objectBuffer, objectPath)
+}
+
+func WriteCompares(repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
+	tags, err := collectTags(repository, index.verifier)
+	if err != nil {
+		return err
+	}
+	pairs, err := comparePairs(repository, index, tags, config)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		err = WriteCompare(pair, repository, index, repositoryName, baseDir, config)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteCompare(pair ComparePair, repository *git.Repository, index *RepoIndex, repositoryName string, baseDir string, config Config) error {
+	baseCommit, err := resolveCompared(repository, pair.Base, pair.baseHash)
+	if err != nil {
+		return err
+	}
+	headCommit, err := resolveCompared(repository, pair.Head, pair.headHash)
+	if err != nil {
+		return err
+	}
+
+	comparePath := filepath.Join(baseDir, comparePath(pair))
+	modTime := headCommit.Committer.When
+	if baseCommit.Committer.When.After(modTime) {
+		modTime = baseCommit.Committer.When
+	}
+	skip, err := isSkipWrite(comparePath, index.verifier.pageTime(modTime))
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(comparePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	root := relRootFromPath(comparePath)
+	data := CompareData{
+		Base: pair.Base,
+		Head: pair.Head,
+	}
+	patch, err := data.fromCommits(baseCommit, headCommit, index, config)
+	if err != nil {
+		return err
+	}
+	// Only the branches which aren't filtered out have tree pages for the lines to link to
+	headBranch := plumbing.NewBranchReferenceName(pair.Head)
+	_, err = repository.Reference(headBranch, false)
+	if err == nil && config.BranchFilter.Allows(pair.Head) {
+		data.Files = root + refDir(headBranch) + "/t/"
+	}
+
+	var diffBuffer bytes.Buffer
+	err = patch.Encode(&diffBuffer)
+	if err != nil {
+		return err
+	}
+	diffPath := strings.TrimSuffix(comparePath, ".html") + ".diff"
+	err = os.WriteFile(diffPath, diffBuffer.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	data.Raw = filepath.Base(diffPath)
+
+	var logData LogData
+	commitIter := object.NewCommitIterCTime(headCommit, index.ancestors(baseCommit.Hash), nil)
+	defer commitIter.Close()
+	err = logData.fromIterAndRefs(commitIter, index.refs, index.verifier, config.LogLimit)
+	if err != nil {
+		return err
+	}
+
+	compareBase := BaseData{
+		Title:     fmt.Sprintf("%s...%s", pair.Base, pair.Head),
+		StylePath: root + config.StylePath,
+		Home:      repositoryName,
+		Root:      root,
+		Nav: NavData{
+			Commit: "",
+			Branch: "",
+		},
+	}
+
+	var compareBuffer bytes.Buffer
+	err = generateCompare(data, logData, index, config.Autolinks, compareBase, &compareBuffer)
+	if err != nil {
+		return err
+	}
+	return writeHtml(&compareBuffer, comparePath)
 }